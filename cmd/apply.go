@@ -0,0 +1,74 @@
+// Copyright © 2017 Rafael Rivero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// Manifest files or folders, and whether to delete unmanaged objects
+var (
+	applyFiles []string
+	applyPrune bool
+)
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply -f <file or folder>",
+	Short: "Make the objects in the server match a set of manifests",
+	Long: `Make the objects in the server match a set of YAML or JSON manifests.
+
+Each manifest lists the desired objects of a type:
+
+    type: role
+    items:
+      - name: Contractor
+        description: Contractors with limited access
+
+  - Known types are static-host-list, role, role-mapping, enforcement-profile,
+    enforcement-policy, network-device, network-device-group, local-user,
+    endpoint and api-client. Objects are matched by their natural key
+    (name, ip_address for network devices, user_id, mac_address or client_id).
+  - Other types are REST collections, and need a 'key' attribute with the
+    name of the natural key. 'path' and 'key' can also override the
+    collection and key of known types (e.g. "key: name").
+  - Folders are read for .yaml, .yml, .json and .hjson files.
+  - The plan is printed before applying it: objects to create (+), update (~)
+    and delete (-). Only the attributes in the manifests are compared and
+    updated. Asks for confirmation unless --yes is given.
+  - With --prune, objects of the types in the manifests that are not
+    listed are deleted. Objects predefined by ClearPass, named in brackets
+    like "[Guest]", and the API client cpcli is logged in with are never
+    deleted. Pruning network devices, local users or API clients asks
+    for confirmation of each type, unless --yes is given.
+  - Use --dry-run to print the plan and requests without sending them.
+    Creations, updates and deletions are saved to the journal (--journal),
+    to be able to roll them back.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(applyFiles) == 0 {
+			Singleton.Fatal(ErrMissingManifest)
+		}
+		if err := Singleton.Apply(applyFiles, applyPrune); err != nil {
+			Singleton.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(applyCmd)
+	applyCmd.Flags().StringArrayVarP(&applyFiles, "filename", "f", nil, "Manifest file or folder (- for stdin), can be repeated")
+	applyCmd.Flags().BoolVar(&applyPrune, "prune", false, "Delete the objects of the managed types that are not in the manifests")
+}
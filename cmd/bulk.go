@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rafahpe/cpcli/term"
+)
+
+// BulkError returned when some of the requests read from stdin failed
+type BulkError struct {
+	Succeeded int
+	Failed    int
+}
+
+func (e BulkError) Error() string {
+	return fmt.Sprintf("%d of %d requests failed", e.Failed, e.Succeeded+e.Failed)
+}
+
+// bulkJob is a request body read from stdin, and the outcome of its request
+type bulkJob struct {
+	item   int // Number of the body in the input
+	body   interface{}
+	record []string // Row of CSV inputs, for rejects
+	output bytes.Buffer
+	err    error
+	done   chan struct{}
+	// Not run, because an earlier request failed with --fail-fast
+	skipped bool
+}
+
+// limiter spaces requests to a max rate. The zero value does not limit.
+type limiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newLimiter builds a limiter for the given requests per second
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return &limiter{}
+	}
+	return &limiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait blocks until the next request is allowed
+func (l *limiter) wait(ctx context.Context) error {
+	if l.interval <= 0 {
+		return ctx.Err()
+	}
+	l.mutex.Lock()
+	at := time.Now()
+	if l.next.After(at) {
+		at = l.next
+	}
+	l.next = at.Add(l.interval)
+	l.mutex.Unlock()
+	select {
+	case <-time.After(time.Until(at)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// summary reports the number of succeeded and failed requests
+func (master *Master) summary(succeeded, failed int) {
+	if master.Errors != ErrorsJSON {
+		master.Log.Printf("%d succeeded, %d failed", succeeded, failed)
+		return
+	}
+	output, _ := json.Marshal(map[string]int{"succeeded": succeeded, "failed": failed})
+	master.Log.Print(string(output))
+}
+
+// bulk runs a request for each body read from the input, with a pool of
+// --parallel workers, at most --rate requests per second. The output of
+// each request is written in input order, unless --unordered.
+// Failed requests are reported and don't stop the run, unless --fail-fast.
+func (master *Master) bulk(ctx context.Context, reader term.Input, run func(ctx context.Context, w io.Writer, body interface{}) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	unordered, workers := master.Unordered, master.Parallel
+	if workers < 1 {
+		workers = 1
+	}
+	limit := newLimiter(master.Rate)
+	jobs := make(chan *bulkJob)
+	// Jobs are queued for output as they are read, or as they finish if unordered
+	results := make(chan *bulkJob, workers)
+	wg := sync.WaitGroup{}
+	// Set by the first failed request with --fail-fast, so the workers
+	// don't take more jobs before the collector cancels the run
+	var stopped int32
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var job *bulkJob
+				select {
+				case job = <-jobs:
+				case <-ctx.Done():
+					return
+				}
+				if job == nil {
+					return
+				}
+				// With a single worker, there is no need to buffer the output
+				var w io.Writer = &job.output
+				if workers == 1 {
+					w = os.Stdout
+				}
+				if atomic.LoadInt32(&stopped) != 0 {
+					job.skipped = true
+				} else if job.err = limit.wait(ctx); job.err == nil {
+					if job.err = run(ctx, w, job.body); job.err != nil && master.FailFast {
+						atomic.StoreInt32(&stopped, 1)
+					}
+				}
+				close(job.done)
+				if unordered {
+					select {
+					case results <- job:
+					case <-ctx.Done():
+					}
+				}
+			}
+		}()
+	}
+	if unordered {
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+	}
+	// Feed the workers
+	var readErr error
+	go func() {
+		defer close(jobs)
+		if !unordered {
+			defer close(results)
+		}
+		for item := 1; reader.Next(); item++ {
+			// Beware of typed nil! reader.Get() may be nil, but it's of type json.RawMessage
+			// body, on the other hand, will be untyped nil.
+			var body interface{}
+			if data := reader.Get(); data != nil {
+				body = data
+			}
+			job := &bulkJob{item: item, body: body, record: term.Record(reader), done: make(chan struct{})}
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+			if !unordered {
+				select {
+				case results <- job:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		readErr = reader.Error()
+	}()
+	// Failed bodies are saved for replay, in the format of the input
+	var rejects *term.Rejects
+	if master.Rejects != "" {
+		file, err := os.Create(master.Rejects)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		rejects = term.NewRejects(file, reader)
+		defer rejects.Close()
+	}
+	// Collect the results
+	succeeded, failed := 0, 0
+	for job := range results {
+		<-job.done
+		if job.skipped {
+			continue
+		}
+		if job.output.Len() > 0 {
+			if _, err := os.Stdout.Write(job.output.Bytes()); err != nil {
+				return err
+			}
+		}
+		if job.err != nil {
+			failed++
+			master.report(job.err, job.item)
+			if rejects != nil {
+				body, _ := job.body.(json.RawMessage)
+				if err := rejects.Write(body, job.record); err != nil {
+					return err
+				}
+			}
+			if master.FailFast {
+				cancel()
+				break
+			}
+			continue
+		}
+		succeeded++
+	}
+	// Don't hide a broken input behind the failed requests. With
+	// --fail-fast, the run stopped before the input was read to the end.
+	if failed > 0 && !master.FailFast && readErr != nil {
+		master.report(errors.Wrap(readErr, "Error reading input"), 0)
+	}
+	master.summary(succeeded, failed)
+	if failed > 0 {
+		return BulkError{Succeeded: succeeded, Failed: failed}
+	}
+	return readErr
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rafahpe/cpcli/term"
+)
+
+// brokenInput yields some items, then fails
+type brokenInput struct {
+	items int
+	err   error
+}
+
+func (i *brokenInput) Next() bool {
+	i.items--
+	return i.items >= 0
+}
+
+func (i *brokenInput) Get() json.RawMessage {
+	return json.RawMessage(`{}`)
+}
+
+func (i *brokenInput) Error() error {
+	return i.err
+}
+
+func TestLimiter(t *testing.T) {
+	tests := []struct {
+		rate float64
+		min  time.Duration
+	}{
+		{0, 0},
+		{100, 20 * time.Millisecond},
+	}
+	for _, test := range tests {
+		limit := newLimiter(test.rate)
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			if err := limit.wait(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed < test.min || (test.min == 0 && elapsed > 10*time.Millisecond) {
+			t.Errorf("rate %v: 3 requests took %s, want at least %s", test.rate, elapsed, test.min)
+		}
+	}
+}
+
+func TestBulk(t *testing.T) {
+	tests := []struct {
+		name     string
+		parallel int
+		failFast bool
+		failAt   int32 // Request that fails, 0 for none
+		runs     int32 // Max requests run
+		err      error
+	}{
+		{"sequential", 1, false, 0, 5, nil},
+		{"parallel", 3, false, 0, 5, nil},
+		{"failure", 3, false, 2, 5, BulkError{Succeeded: 4, Failed: 1}},
+		{"fail fast", 1, true, 2, 2, BulkError{Succeeded: 1, Failed: 1}},
+	}
+	for _, test := range tests {
+		master := &Master{Log: log.New(&bytes.Buffer{}, "", 0)}
+		master.Parallel, master.FailFast = test.parallel, test.failFast
+		var runs int32
+		err := master.bulk(context.Background(), &brokenInput{items: 5}, func(ctx context.Context, w io.Writer, body interface{}) error {
+			if atomic.AddInt32(&runs, 1) == test.failAt {
+				return errors.New("request failed")
+			}
+			return nil
+		})
+		if err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+		if runs > test.runs || (test.err == nil && runs != test.runs) {
+			t.Errorf("%s: got %d requests, want %d", test.name, runs, test.runs)
+		}
+	}
+}
+
+func TestBulkReportsInputError(t *testing.T) {
+	readErr := errors.New("unexpected end of input")
+	tests := []struct {
+		name     string
+		runErr   error
+		reported bool
+	}{
+		{"requests failed", errors.New("request failed"), true},
+		{"requests succeeded", nil, false},
+	}
+	for _, test := range tests {
+		logs := &bytes.Buffer{}
+		master := &Master{Log: log.New(logs, "", 0)}
+		master.Parallel = 2
+		err := master.bulk(context.Background(), &brokenInput{items: 2, err: readErr}, func(ctx context.Context, w io.Writer, body interface{}) error {
+			return test.runErr
+		})
+		if test.runErr != nil {
+			if _, ok := err.(BulkError); !ok {
+				t.Errorf("%s: got %v, want BulkError", test.name, err)
+			}
+		} else if err != readErr {
+			t.Errorf("%s: got %v, want %v", test.name, err, readErr)
+		}
+		if reported := strings.Contains(logs.String(), readErr.Error()); reported != test.reported {
+			t.Errorf("%s: input error reported = %v, log %q", test.name, reported, logs.String())
+		}
+	}
+}
+
+func TestBulkRejects(t *testing.T) {
+	input, err := term.NewInput(strings.NewReader("name,enabled:bool\na,yes\nb,no\nc,yes\n"), term.InputCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := &Master{Log: log.New(&bytes.Buffer{}, "", 0)}
+	master.Parallel = 2
+	master.Rejects = filepath.Join(t.TempDir(), "rejects.csv")
+	err = master.bulk(context.Background(), input, func(ctx context.Context, w io.Writer, body interface{}) error {
+		if strings.Contains(string(body.(json.RawMessage)), `"b"`) {
+			return nil
+		}
+		return errors.New("request failed")
+	})
+	if _, ok := err.(BulkError); !ok {
+		t.Errorf("got %v, want BulkError", err)
+	}
+	rejects, err := ioutil.ReadFile(master.Rejects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "name,enabled:bool\na,yes\nc,yes\n"; string(rejects) != want {
+		t.Errorf("got rejects %q, want %q", rejects, want)
+	}
+}
@@ -0,0 +1,329 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rafahpe/cpcli/model"
+	"github.com/rafahpe/cpcli/term"
+)
+
+// DiffOptions for the diff command
+type DiffOptions struct {
+	Key     string   // Natural key of the objects, default depends on the path
+	Ignore  []string // More attributes to ignore, besides the volatile ones
+	Unified bool     // Unified text diff instead of field by field
+}
+
+// Status of an object in a diff
+const (
+	diffRemoved = "removed" // Only in the left source
+	diffAdded   = "added"   // Only in the right source
+	diffChanged = "changed"
+)
+
+// diffSource is a server or a snapshot folder to compare
+type diffSource struct {
+	name   string
+	cppm   model.Clearpass // Client for the profile, nil for snapshots
+	folder string
+}
+
+// fieldDiff is an attribute that differs between the sources
+type fieldDiff struct {
+	Field string      `json:"field"`
+	Left  interface{} `json:"left"`
+	Right interface{} `json:"right"`
+}
+
+// objectDiff is an object that differs between the sources
+type objectDiff struct {
+	Key    string      `json:"key"`
+	Status string      `json:"status"`
+	Fields []fieldDiff `json:"fields,omitempty"`
+	left   map[string]interface{}
+	right  map[string]interface{}
+}
+
+// volatile checks if the attribute changes from server to server, or
+// over time: ids, links and timestamps. Timestamps are told by their
+// suffix, so attributes like "created_by" are still compared.
+func volatile(name string) bool {
+	name = strings.ToLower(name)
+	if name == "id" || name == "_links" {
+		return true
+	}
+	for _, suffix := range []string{"_at", "_time", "timestamp", "created", "updated", "modified"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffSource returns a snapshot folder, or a client for the profile
+func (master *Master) diffSource(name string) (diffSource, error) {
+	if info, err := os.Stat(name); err == nil && info.IsDir() {
+		return diffSource{name: name, folder: name}, nil
+	}
+	cppm, err := master.profileClient(name)
+	if err != nil {
+		return diffSource{}, err
+	}
+	return diffSource{name: name, cppm: cppm}, nil
+}
+
+// objects reads the objects of the collection from the source
+func (s diffSource) objects(ctx context.Context, path string) ([]map[string]interface{}, error) {
+	if s.cppm != nil {
+		return collection(ctx, s.cppm, path)
+	}
+	return readSnapshot(s.folder, path)
+}
+
+// byKey indexes the objects by natural key. Objects without key are skipped.
+func (master *Master) byKey(source string, objects []map[string]interface{}, key string) (map[string]map[string]interface{}, error) {
+	result := make(map[string]map[string]interface{}, len(objects))
+	skipped := 0
+	for _, object := range objects {
+		name, ok := naturalKey(object, key)
+		if !ok {
+			skipped++
+			continue
+		}
+		if _, dup := result[name]; dup {
+			return nil, fmt.Errorf("Duplicate key '%s' in %s, use --key to choose another attribute", name, source)
+		}
+		result[name] = object
+	}
+	if skipped > 0 {
+		master.Log.Printf("%d objects without '%s' in %s skipped", skipped, key, source)
+	}
+	return result, nil
+}
+
+// clean returns a copy of the object without volatile or ignored attributes
+func clean(prefix string, object map[string]interface{}, ignore map[string]bool) map[string]interface{} {
+	result := make(map[string]interface{}, len(object))
+	for name, value := range object {
+		field := name
+		if prefix != "" {
+			field = prefix + "." + name
+		}
+		if volatile(name) || ignore[name] || ignore[field] {
+			continue
+		}
+		if inner, ok := value.(map[string]interface{}); ok {
+			value = clean(field, inner, ignore)
+		}
+		result[name] = value
+	}
+	return result
+}
+
+// flatten collects the attributes of the object by dotted path,
+// e.g. "attributes.Location". Arrays are compared as a whole.
+func flatten(prefix string, object map[string]interface{}, result map[string]interface{}) {
+	for name, value := range object {
+		field := name
+		if prefix != "" {
+			field = prefix + "." + name
+		}
+		if inner, ok := value.(map[string]interface{}); ok && len(inner) > 0 {
+			flatten(field, inner, result)
+			continue
+		}
+		result[field] = value
+	}
+}
+
+// diffFields compares the attributes of two objects
+func diffFields(left, right map[string]interface{}) []fieldDiff {
+	leftFields, rightFields := make(map[string]interface{}), make(map[string]interface{})
+	flatten("", left, leftFields)
+	flatten("", right, rightFields)
+	names := make([]string, 0, len(leftFields)+len(rightFields))
+	for name := range leftFields {
+		names = append(names, name)
+	}
+	for name := range rightFields {
+		if _, ok := leftFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	fields := make([]fieldDiff, 0, 4)
+	for _, name := range names {
+		l, inLeft := leftFields[name]
+		r, inRight := rightFields[name]
+		// Secrets are not returned by every server, nor saved in snapshots
+		if (!inLeft || !inRight) && model.Sensitive(name) {
+			continue
+		}
+		if !matchValue(l, r) || !matchValue(r, l) {
+			fields = append(fields, fieldDiff{Field: name, Left: l, Right: r})
+		}
+	}
+	return fields
+}
+
+// compareObjects returns the objects that differ, sorted by key
+func compareObjects(left, right map[string]map[string]interface{}, ignore map[string]bool) []objectDiff {
+	keys := sortedKeys(left)
+	for key := range right {
+		if _, ok := left[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	diffs := make([]objectDiff, 0, 8)
+	for _, key := range keys {
+		l, inLeft := left[key]
+		r, inRight := right[key]
+		diff := objectDiff{Key: key}
+		if inLeft {
+			diff.left = clean("", l, ignore)
+		}
+		if inRight {
+			diff.right = clean("", r, ignore)
+		}
+		switch {
+		case !inRight:
+			diff.Status = diffRemoved
+		case !inLeft:
+			diff.Status = diffAdded
+		default:
+			if diff.Fields = diffFields(diff.left, diff.right); len(diff.Fields) == 0 {
+				continue
+			}
+			diff.Status = diffChanged
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+// maskField hides the value of secret attributes
+func maskField(field string, value interface{}) interface{} {
+	if value == nil || model.ShowSecrets || !model.Sensitive(field) {
+		return value
+	}
+	return model.Redacted
+}
+
+// textLines returns the object as indented JSON lines, with secrets
+// masked. Absent objects have no lines.
+func textLines(object map[string]interface{}) ([]string, error) {
+	if object == nil {
+		return nil, nil
+	}
+	data, err := json.MarshalIndent(object, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(model.RedactBody(data)), "\n"), nil
+}
+
+// Diff compares the collection in the path between two sources: the
+// active profile and a profile or snapshot, or two of them.
+func (master *Master) Diff(path string, sources []string, options DiffOptions) error {
+	if path == "" {
+		return ErrMissingPath
+	}
+	left := diffSource{name: master.Profile, cppm: master.cppm}
+	if left.name == "" {
+		left.name = master.getString("server")
+	}
+	var err error
+	if len(sources) > 1 {
+		if left, err = master.diffSource(sources[0]); err != nil {
+			return err
+		}
+		sources = sources[1:]
+	}
+	right, err := master.diffSource(sources[0])
+	if err != nil {
+		return err
+	}
+	key := options.Key
+	if key == "" {
+		key = defaultKey(path)
+	}
+	ignore := make(map[string]bool, len(options.Ignore))
+	for _, name := range options.Ignore {
+		ignore[name] = true
+	}
+	ctx := context.Background()
+	indexed := make([]map[string]map[string]interface{}, 0, 2)
+	for _, source := range []diffSource{left, right} {
+		objects, err := source.objects(ctx, path)
+		if err != nil {
+			return fmt.Errorf("%s: %s", source.name, err)
+		}
+		index, err := master.byKey(source.name, objects, key)
+		if err != nil {
+			return err
+		}
+		indexed = append(indexed, index)
+	}
+	diffs := compareObjects(indexed[0], indexed[1], ignore)
+	path = strings.Trim(path, "/")
+	switch {
+	case options.Unified:
+		for _, diff := range diffs {
+			a, err := textLines(diff.left)
+			if err != nil {
+				return err
+			}
+			b, err := textLines(diff.right)
+			if err != nil {
+				return err
+			}
+			if err := term.Unified(os.Stdout, left.name+"/"+path+"/"+diff.Key, right.name+"/"+path+"/"+diff.Key, a, b, 3); err != nil {
+				return err
+			}
+		}
+		return nil
+	case master.Options.Output == "json":
+		for i := range diffs {
+			for j, field := range diffs[i].Fields {
+				diffs[i].Fields[j].Left = maskField(field.Field, field.Left)
+				diffs[i].Fields[j].Right = maskField(field.Field, field.Right)
+			}
+		}
+		report := map[string]interface{}{"left": left.name, "right": right.name, "path": path, "objects": diffs}
+		var data []byte
+		if master.Options.PrettyPrint {
+			data, err = json.MarshalIndent(report, "", "  ")
+		} else {
+			data, err = json.Marshal(report)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(model.RedactBody(data)))
+		return nil
+	}
+	counts := make(map[string]int, 3)
+	fmt.Printf("--- %s\n+++ %s\n", left.name, right.name)
+	for _, diff := range diffs {
+		counts[diff.Status]++
+		switch diff.Status {
+		case diffRemoved:
+			fmt.Printf("- %s \"%s\"\n", path, diff.Key)
+		case diffAdded:
+			fmt.Printf("+ %s \"%s\"\n", path, diff.Key)
+		default:
+			fmt.Printf("~ %s \"%s\"\n", path, diff.Key)
+			for _, field := range diff.Fields {
+				fmt.Printf("    %s: %s => %s\n", field.Field, planValue(field.Field, field.Left), planValue(field.Field, field.Right))
+			}
+		}
+	}
+	fmt.Printf("\n%d only in %s, %d only in %s, %d different.\n", counts[diffRemoved], left.name, counts[diffAdded], right.name, counts[diffChanged])
+	return nil
+}
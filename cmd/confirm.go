@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rafahpe/cpcli/model"
+	"github.com/rafahpe/cpcli/term"
+)
+
+const (
+	// ErrNotConfirmed returned when the user does not confirm a destructive request
+	ErrNotConfirmed = Error("Request cancelled")
+	// ErrNeedsYes returned when a destructive request can't be confirmed
+	ErrNeedsYes = Error("Refusing to run a destructive request without a terminal to confirm it, use --yes to go on")
+)
+
+// Terminal to confirm the requests, replaced in tests
+var (
+	interactive = term.Interactive
+	readline    = term.Readline
+)
+
+// destructive checks if the request removes objects, or may
+// overwrite several of them at once
+func destructive(method model.Method, query model.Params) bool {
+	if method == model.DELETE {
+		return true
+	}
+	_, filtered := query["filter"]
+	return filtered && (method == model.PATCH || method == model.PUT)
+}
+
+// confirm asks the user before running destructive requests,
+// telling how many objects will be affected. Skipped with --yes.
+func (master *Master) confirm(method model.Method, path string, query model.Params, tmpl *requestTemplate) error {
+	if master.Yes || master.DryRun || !destructive(method, query) {
+		return nil
+	}
+	if !interactive() {
+		return ErrNeedsYes
+	}
+	var question string
+	if tmpl != nil {
+		// The objects depend on the bodies, they can't be counted in advance
+		question = fmt.Sprintf("%s one object at %s for each body?", method, path)
+	} else {
+		count, found, err := master.matches(path, query)
+		if err != nil {
+			return err
+		}
+		switch {
+		case count >= 0:
+			question = fmt.Sprintf("%s %d objects at %s?", method, count, path)
+		case found:
+			question = fmt.Sprintf("%s the object at %s?", method, path)
+		default:
+			question = fmt.Sprintf("%s an unknown number of objects at %s?", method, path)
+		}
+	}
+	return master.ask(question)
+}
+
+// ask prompts the user to confirm, unless --yes or --dry-run.
+// Returns ErrNotConfirmed if the answer is not yes.
+func (master *Master) ask(question string) error {
+	if master.Yes || master.DryRun {
+		return nil
+	}
+	if !interactive() {
+		return ErrNeedsYes
+	}
+	answer, err := readline(question+" [y/N] ", false)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return nil
+	}
+	return ErrNotConfirmed
+}
+
+// dryRun writes the request that would be sent, with secrets masked
+func (master *Master) dryRun(w io.Writer, method model.Method, path string, query model.Params, body interface{}) error {
+	address, params, err := master.cppm.Resolve(path, query)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s %s\n", method, address); err != nil {
+		return err
+	}
+	// Query params are written unescaped, one per line, to be readable
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		param := model.RedactBody([]byte(key + "=" + params[key]))
+		if _, err := fmt.Fprintf(w, "  %s\n", param); err != nil {
+			return err
+		}
+	}
+	if body == nil {
+		return nil
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(model.RedactBody(data)))
+	return err
+}
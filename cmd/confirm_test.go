@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rafahpe/cpcli/model"
+	"github.com/rafahpe/cpcli/term"
+)
+
+// fakeTerminal replaces the terminal with the answers, and returns
+// the questions asked.
+func fakeTerminal(t *testing.T, tty bool, answers string) *[]string {
+	questions := &[]string{}
+	reader := bufio.NewReader(strings.NewReader(answers))
+	interactive = func() bool { return tty }
+	readline = func(prompt string, password bool) (string, error) {
+		*questions = append(*questions, prompt)
+		answer, err := reader.ReadString('\n')
+		return strings.TrimSpace(answer), err
+	}
+	t.Cleanup(func() {
+		interactive, readline = term.Interactive, term.Readline
+	})
+	return questions
+}
+
+func TestDestructive(t *testing.T) {
+	filter := model.Params{"filter": `{"name":"x"}`}
+	tests := []struct {
+		method model.Method
+		query  model.Params
+		want   bool
+	}{
+		{model.GET, nil, false},
+		{model.GET, filter, false},
+		{model.POST, filter, false},
+		{model.DELETE, nil, true},
+		{model.DELETE, filter, true},
+		{model.PATCH, nil, false},
+		{model.PATCH, model.Params{"limit": "1"}, false},
+		{model.PATCH, filter, true},
+		{model.PUT, nil, false},
+		{model.PUT, filter, true},
+	}
+	for _, test := range tests {
+		if got := destructive(test.method, test.query); got != test.want {
+			t.Errorf("destructive(%s, %v) = %v, want %v", test.method, test.query, got, test.want)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   model.Method
+		path     string
+		template bool
+		yes      bool
+		dryRun   bool
+		tty      bool
+		answer   string
+		question string // Question asked, if any
+		err      error
+	}{
+		{"get", model.GET, "endpoint", false, false, false, false, "", "", nil},
+		{"yes", model.DELETE, "endpoint", false, true, false, false, "", "", nil},
+		{"dry run", model.DELETE, "endpoint", false, false, true, false, "", "", nil},
+		{"no terminal", model.DELETE, "endpoint", false, false, false, false, "", "", ErrNeedsYes},
+		{"confirmed", model.DELETE, "endpoint", false, false, false, true, "y\n", "DELETE 3 objects at endpoint? [y/N] ", nil},
+		{"confirmed yes", model.PATCH, "endpoint", false, false, false, true, "YES\n", "PATCH 3 objects at endpoint? [y/N] ", nil},
+		{"refused", model.DELETE, "endpoint", false, false, false, true, "n\n", "DELETE 3 objects at endpoint? [y/N] ", ErrNotConfirmed},
+		{"default answer", model.DELETE, "endpoint", false, false, false, true, "\n", "DELETE 3 objects at endpoint? [y/N] ", ErrNotConfirmed},
+		{"single object", model.DELETE, "endpoint/1", false, false, false, true, "y\n", "DELETE the object at endpoint/1? [y/N] ", nil},
+		{"template", model.DELETE, "endpoint/{{.id}}", true, false, false, true, "y\n", "DELETE one object at endpoint/{{.id}} for each body? [y/N] ", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			questions := fakeTerminal(t, test.tty, test.answer)
+			master := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if strings.HasSuffix(r.URL.Path, "/1") {
+					w.Write([]byte(`{"id":1}`))
+					return
+				}
+				w.Write([]byte(`{"_embedded":{"items":[{"id":1}]},"count":3}`))
+			}, "token", time.Time{})
+			master.Yes, master.DryRun = test.yes, test.dryRun
+			query := model.Params{"filter": `{"name":"x"}`}
+			var tmpl *requestTemplate
+			if test.template {
+				tmpl = &requestTemplate{}
+			}
+			if err := master.confirm(test.method, test.path, query, tmpl); err != test.err {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+			asked := strings.Join(*questions, "\n")
+			if asked != test.question {
+				t.Errorf("asked %q, want %q", asked, test.question)
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		method model.Method
+		path   string
+		query  model.Params
+		body   interface{}
+		want   string
+	}{
+		{"get", model.GET, "endpoint", nil, nil, "GET https://%s/api/endpoint\n"},
+		{"query", model.DELETE, "endpoint", model.Params{"filter": `{"name":"x y"}`, "limit": "10"}, nil,
+			"DELETE https://%s/api/endpoint\n  calculate_count=false\n  filter={\"name\":\"x y\"}\n  limit=10\n"},
+		{"secret query", model.GET, "endpoint", model.Params{"token": "abc"}, nil, "GET https://%s/api/endpoint\n  token=[REDACTED]\n"},
+		{"body", model.POST, "local-user", nil, map[string]interface{}{"user_id": "u", "password": "p"},
+			"POST https://%s/api/local-user\n{\"password\":\"[REDACTED]\",\"user_id\":\"u\"}\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int32
+			master := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+			}, "token", time.Time{})
+			output := &bytes.Buffer{}
+			if err := master.dryRun(output, test.method, test.path, test.query, test.body); err != nil {
+				t.Fatal(err)
+			}
+			want := strings.Replace(test.want, "%s", master.getString("server"), 1)
+			if output.String() != want {
+				t.Errorf("got %q, want %q", output.String(), want)
+			}
+			if requests := atomic.LoadInt32(&requests); requests != 0 {
+				t.Errorf("dry run sent %d requests", requests)
+			}
+		})
+	}
+}
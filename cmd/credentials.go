@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/rafahpe/cpcli/term"
+)
+
+// Credentials are read from files, stdin and credential helpers in
+// git-credential format: one "key=value" pair per line, e.g.
+//
+//	secret=client secret
+//	password=user password
+//
+// Credential helpers are invoked with a "get" argument, like git does,
+// and receive "protocol", "host" and "username" in their standard input.
+// They must reply with the secret in the "password" key.
+type credentials map[string]string
+
+// Environment variables for the client secret and user password
+var credentialEnv = map[string]string{
+	"secret":   "CPPM_SECRET",
+	"password": "CPPM_PASSWORD",
+}
+
+// parseCredentials reads key=value pairs until EOF or a blank line
+func parseCredentials(r io.Reader) (credentials, error) {
+	creds := make(credentials)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("Credentials must be in 'key=value' format, got '%s'", parts[0])
+		}
+		creds[strings.TrimSpace(parts[0])] = parts[1]
+	}
+	return creds, scanner.Err()
+}
+
+// readCredentials loads the credentials from --secret-file or --secret-stdin.
+// Returns nil if none of them is configured.
+func (master *Master) readCredentials() (credentials, error) {
+	if master.creds != nil {
+		return master.creds, nil
+	}
+	var err error
+	switch {
+	case master.SecretFile != "":
+		var f *os.File
+		if f, err = os.Open(master.SecretFile); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		master.creds, err = parseCredentials(f)
+	case master.SecretStdin:
+		master.creds, err = parseCredentials(os.Stdin)
+	}
+	return master.creds, err
+}
+
+// runHelper asks the credential helper for the password of the given user
+func runHelper(helper, server, username string) (string, error) {
+	args := strings.Fields(helper)
+	cmd := exec.Command(args[0], append(args[1:], "get")...)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=https\nhost=%s\nusername=%s\n\n", server, username))
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Credential helper '%s' failed: %s", helper, err)
+	}
+	creds, err := parseCredentials(bytes.NewReader(output))
+	if err != nil {
+		return "", err
+	}
+	return creds["password"], nil
+}
+
+// askSecret returns the client secret ("secret") or user password
+// ("password") for the given username, from the first source available:
+//
+//   - CPPM_SECRET / CPPM_PASSWORD environment variables.
+//   - The file given with --secret-file, or stdin with --secret-stdin.
+//   - The command in the 'credential-helper' config variable.
+//   - Interactive prompt.
+func (master *Master) askSecret(name, server, username, prompt string) (string, error) {
+	value, ok, err := master.storedSecret(name, server, username)
+	if ok || err != nil {
+		return value, err
+	}
+	return term.Readline(prompt, true)
+}
+
+// storedSecret returns the client secret or user password from the
+// sources of askSecret that don't prompt. ok is false if none has it.
+func (master *Master) storedSecret(name, server, username string) (value string, ok bool, err error) {
+	if value, ok := os.LookupEnv(credentialEnv[name]); ok {
+		return value, true, nil
+	}
+	creds, err := master.readCredentials()
+	if err != nil {
+		return "", false, err
+	}
+	// Files without the key fall through to the next source
+	if value, ok := creds[name]; ok {
+		return value, true, nil
+	}
+	if helper := master.getString("credential-helper"); strings.TrimSpace(helper) != "" {
+		value, err := runHelper(helper, server, username)
+		return value, err == nil, err
+	}
+	return "", false, nil
+}
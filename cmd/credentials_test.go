@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestParseCredentials(t *testing.T) {
+	tests := []struct {
+		input string
+		want  credentials
+		fails bool
+	}{
+		{"", credentials{}, false},
+		{"secret=abc\npassword=a=b\n", credentials{"secret": "abc", "password": "a=b"}, false},
+		{"secret=abc\r\n\r\npassword=ignored\n", credentials{"secret": "abc"}, false},
+		{"password=\n", credentials{"password": ""}, false},
+		{"no equal sign\n", nil, true},
+	}
+	for _, test := range tests {
+		got, err := parseCredentials(strings.NewReader(test.input))
+		if (err != nil) != test.fails {
+			t.Errorf("parseCredentials(%q) error = %v", test.input, err)
+			continue
+		}
+		if !test.fails && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseCredentials(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestAskSecret(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	// Keys missing in the file are asked to the credential helper
+	viper.Set("credential-helper", "printf password=helper")
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"secret", "abc"},
+		{"password", "helper"},
+	}
+	for _, test := range tests {
+		master := &Master{creds: credentials{"secret": "abc"}}
+		got, err := master.askSecret(test.name, "cppm", "client", "")
+		if err != nil || got != test.want {
+			t.Errorf("askSecret(%s) = %q, %v, want %q", test.name, got, err, test.want)
+		}
+	}
+}
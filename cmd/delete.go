@@ -0,0 +1,48 @@
+// Copyright © 2017 Rafael Rivero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/rafahpe/cpcli/model"
+	"github.com/spf13/cobra"
+)
+
+// deleteCmd represents the delete command
+var deleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Make a DELETE request",
+	Long: `Make a DELETE request, to remove existing objects.
+
+  - The first parameter is the path of the object, e.g. "endpoint/1234".
+  - The path can be a template filled from each JSON object piped to stdin,
+    e.g. 'endpoint/{{.id}}', to delete the objects listed by a "get".
+  - Asks for confirmation, telling how many objects match, unless --yes
+    is given. Without a terminal to ask, --yes is required.
+  - Use --dry-run to print the requests without sending them.
+  - Each object is saved before and after the change to the journal
+    (--journal), so it can be undone with "rollback <file>" or
+    "rollback --last".
+  - If more parameters are provided, they are considered attributes to dump
+    from the reply, if the server returns any.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := Singleton.Run(model.DELETE, args); err != nil {
+			Singleton.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(deleteCmd)
+}
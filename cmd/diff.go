@@ -0,0 +1,55 @@
+// Copyright © 2017 Rafael Rivero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// Options of the diff command
+var diffOptions DiffOptions
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <path> [<profile or snapshot>] <profile or snapshot>",
+	Short: "Compare a collection between two profiles or snapshots",
+	Long: `Compare the objects of a collection (e.g. "role") between two sources.
+
+  - Each source is a profile of the config file, or a snapshot folder
+    written by the "snapshot" command. If only one is given, the active
+    profile is compared to it.
+  - Objects are matched by their natural key: name, or ip_address, user_id,
+    mac_address or client_id for network devices, local users, endpoints and
+    API clients. Use --key to choose another attribute (e.g. --key name).
+  - Volatile attributes (id, _links and timestamps) are not compared, nor
+    secrets missing in one of the sources. Use --ignore to skip more
+    attributes, by name or dotted path.
+  - The output lists the objects only in the first source (-), only in the
+    second (+), and the attributes that differ (~). Use -o json for the
+    same report as a JSON object, or --unified for a text diff of each object.`,
+	Args: cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := Singleton.Diff(args[0], args[1:], diffOptions); err != nil {
+			Singleton.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringVar(&diffOptions.Key, "key", "", "Attribute to match objects by (default depends on the path, usually 'name')")
+	diffCmd.Flags().StringArrayVar(&diffOptions.Ignore, "ignore", nil, "Attribute not to compare, by name or dotted path (e.g. 'description', 'attributes.Owner'), can be repeated")
+	diffCmd.Flags().BoolVar(&diffOptions.Unified, "unified", false, "Print a unified text diff of each object instead of the changed attributes")
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		left  map[string]interface{}
+		right map[string]interface{}
+		want  string // Fields that differ
+	}{
+		{"equal", map[string]interface{}{"name": "sw"}, map[string]interface{}{"name": "sw"}, ""},
+		{"changed", map[string]interface{}{"name": "sw", "vendor": "Aruba"}, map[string]interface{}{"name": "sw", "vendor": "Cisco"}, "vendor"},
+		{"nested", map[string]interface{}{"attributes": map[string]interface{}{"Location": "a", "Owner": "me"}},
+			map[string]interface{}{"attributes": map[string]interface{}{"Location": "b", "Owner": "me"}}, "attributes.Location"},
+		{"added and removed", map[string]interface{}{"a": "1"}, map[string]interface{}{"b": "2"}, "a,b"},
+		{"missing secret", map[string]interface{}{"name": "sw", "radius_secret": "s"}, map[string]interface{}{"name": "sw"}, ""},
+		{"changed secret", map[string]interface{}{"radius_secret": "s"}, map[string]interface{}{"radius_secret": "t"}, "radius_secret"},
+	}
+	for _, test := range tests {
+		fields := diffFields(test.left, test.right)
+		names := make([]string, 0, len(fields))
+		for _, field := range fields {
+			names = append(names, field.Field)
+		}
+		if got := strings.Join(names, ","); got != test.want {
+			t.Errorf("%s: got fields %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestVolatile(t *testing.T) {
+	tests := map[string]bool{
+		"id":               true,
+		"_links":           true,
+		"created_at":       true,
+		"LastUpdated":      true,
+		"date_modified":    true,
+		"timestamp":        true,
+		"update_time":      true,
+		"created_by":       false,
+		"last_modified_by": false,
+		"name":             false,
+		"attributes":       false,
+		"last_name":        false,
+		"last_known_ip":    false,
+	}
+	for name, want := range tests {
+		if got := volatile(name); got != want {
+			t.Errorf("volatile(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rafahpe/cpcli/model"
+)
+
+// Exit codes, so scripts can tell failures apart
+const (
+	ExitError       = 1 // Any other error
+	ExitNotLoggedIn = 3 // Missing, invalid or expired credentials
+	ExitClientError = 4 // Request rejected by the server (4xx)
+	ExitServerError = 5 // Server failed to process the request (5xx)
+	ExitNetwork     = 6 // No reply from the server
+	ExitPartial     = 7 // Some of the requests read from stdin failed
+)
+
+// Formats for the error messages, see --errors
+const (
+	ErrorsText = "text"
+	ErrorsJSON = "json"
+)
+
+// ErrUnknownErrors returned when the error format is not supported
+const ErrUnknownErrors = Error("Unknown error format, must be 'text' or 'json'")
+
+// errorReport is the JSON rendering of an error, for --errors=json
+type errorReport struct {
+	Error    string           `json:"error"`
+	Item     int              `json:"item,omitempty"`
+	ExitCode int              `json:"exit_code"`
+	Request  *model.RestError `json:"request,omitempty"`
+}
+
+// exitCode returns the exit code for the error
+func exitCode(err error) int {
+	cause := errors.Cause(err)
+	switch cause {
+	case model.ErrNotLoggedIn, model.ErrCannotRefresh, ErrMissingCreds, ErrInvalidCreds:
+		return ExitNotLoggedIn
+	}
+	switch e := cause.(type) {
+	case BulkError:
+		return ExitPartial
+	case model.RestError:
+		switch {
+		case e.Err == model.ErrNotLoggedIn:
+			return ExitNotLoggedIn
+		case e.StatusCode == 0:
+			return ExitNetwork
+		case e.StatusCode >= 500:
+			return ExitServerError
+		case e.StatusCode >= 400:
+			return ExitClientError
+		}
+	case net.Error:
+		return ExitNetwork
+	}
+	return ExitError
+}
+
+// report writes the error to the log, as text or JSON depending on
+// --errors. item is the number of the body read from stdin that failed,
+// if any: bodies may span several lines, or be items of an array.
+func (master *Master) report(err error, item int) {
+	if master.Errors != ErrorsJSON {
+		if item > 0 {
+			master.Log.Printf("Item %d: %s", item, err)
+		} else {
+			master.Log.Print(err)
+		}
+		return
+	}
+	report := errorReport{Error: err.Error(), Item: item, ExitCode: exitCode(err)}
+	if restErr, ok := errors.Cause(err).(model.RestError); ok {
+		// Replace the full dump of the request with the short message
+		if restErr.Err != nil {
+			report.Error = strings.Replace(report.Error, restErr.Error(), restErr.Err.Error(), 1)
+		}
+		report.Request = &restErr
+	}
+	output, jsonErr := json.Marshal(report)
+	if jsonErr != nil {
+		master.Log.Print(err)
+		return
+	}
+	master.Log.Print(string(output))
+}
+
+// Fatal reports the error and exits with the matching exit code
+func (master *Master) Fatal(err error) {
+	master.report(err, 0)
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rafahpe/cpcli/model"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"generic", errors.New("failed"), ExitError},
+		{"usage", ErrMissingPath, ExitError},
+		{"unknown format", ErrUnknownErrors, ExitError},
+		{"not logged in", model.ErrNotLoggedIn, ExitNotLoggedIn},
+		{"missing credentials", errors.Wrap(ErrMissingCreds, "Login error"), ExitNotLoggedIn},
+		{"token rejected", model.RestError{Err: model.ErrNotLoggedIn, StatusCode: 401}, ExitNotLoggedIn},
+		{"cannot refresh", model.ErrCannotRefresh, ExitNotLoggedIn},
+		{"not found", model.RestError{StatusCode: 404}, ExitClientError},
+		{"validation", errors.Wrap(model.RestError{StatusCode: 422}, "Request error"), ExitClientError},
+		{"server error", model.RestError{StatusCode: 503}, ExitServerError},
+		{"no reply", model.RestError{Err: errors.New("connection refused")}, ExitNetwork},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, ExitNetwork},
+		{"partial", BulkError{Succeeded: 2, Failed: 1}, ExitPartial},
+	}
+	for _, test := range tests {
+		if got := exitCode(test.err); got != test.want {
+			t.Errorf("%s: got exit code %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	restErr := model.RestError{
+		Err:        errors.New("Unprocessable entity"),
+		Method:     model.POST,
+		URL:        "https://cppm.example.com/api/endpoint",
+		Body:       []byte(`{"mac_address":"00:11:22:33:44:55"}`),
+		StatusCode: 422,
+		Reply:      []byte("not json"),
+		Attempts: []model.Attempt{
+			{StatusCode: 503, Delay: time.Second},
+			{Err: errors.New("timeout"), Delay: 2 * time.Second},
+		},
+	}
+	tests := []struct {
+		name   string
+		format string
+		err    error
+		item   int
+		want   string
+	}{
+		{"text", ErrorsText, errors.New("failed"), 0, "failed\n"},
+		{"text with item", ErrorsText, errors.New("failed"), 3, "Item 3: failed\n"},
+		{"json", ErrorsJSON, errors.New("failed"), 0, `{"error":"failed","exit_code":1}`},
+		{"json with item", ErrorsJSON, BulkError{Failed: 1}, 2, `{"error":"1 of 1 requests failed","item":2,"exit_code":7}`},
+		{"json request", ErrorsJSON, errors.Wrap(restErr, "Request error"), 4, `{
+			"error": "Request error: Unprocessable entity",
+			"item": 4,
+			"exit_code": 4,
+			"request": {
+				"error": "Unprocessable entity",
+				"method": "POST",
+				"url": "https://cppm.example.com/api/endpoint",
+				"status_code": 422,
+				"body": {"mac_address": "00:11:22:33:44:55"},
+				"reply": "not json",
+				"attempts": [
+					{"status_code": 503, "delay": "1s"},
+					{"error": "timeout", "delay": "2s"}
+				]
+			}
+		}`},
+	}
+	for _, test := range tests {
+		output := &bytes.Buffer{}
+		master := &Master{Log: log.New(output, "", 0), Errors: test.format}
+		master.report(test.err, test.item)
+		if test.format == ErrorsText {
+			if output.String() != test.want {
+				t.Errorf("%s: got %q, want %q", test.name, output.String(), test.want)
+			}
+			continue
+		}
+		var got, want interface{}
+		if err := json.Unmarshal(output.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %s in %q", test.name, err, output.String())
+		}
+		if err := json.Unmarshal([]byte(test.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %s", test.name, output.String())
+		}
+	}
+}
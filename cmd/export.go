@@ -30,7 +30,7 @@ var exportCmd = &cobra.Command{
   - Second attribute is the password to protect the downloaded zip file.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if fname, err := Singleton.Export(args); err != nil {
-			Singleton.Log.Fatal(err)
+			Singleton.Fatal(err)
 		} else {
 			fmt.Println("Resource", args[0], "exported to file", fname)
 		}
@@ -27,10 +27,15 @@ var getCmd = &cobra.Command{
 
   - If no parameters provided, list the whole endpoint data as a JSON object.
   - If some parameters are provided, they are considered attributes to dump,
-    for instance "mac_address", "attributes.Username"`,
+    for instance "mac_address", "attributes.Username"
+  - Attributes can index arrays ("tags[0]", "tags[-1]"), use wildcards
+    ("attributes.*", "items[*].name") and be renamed ("owner=attributes.Owner")
+  - Use --jq to transform each reply with a jq expression before output.
+  - Use --limit, --offset, --sort and --max to control paging, or --count
+    to get just the total number of items.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := Singleton.Run(model.GET, args); err != nil {
-			Singleton.Log.Fatal(err)
+			Singleton.Fatal(err)
 		}
 	},
 }
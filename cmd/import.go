@@ -31,7 +31,7 @@ var importCmd = &cobra.Command{
   - Third argument is the password to protect the downloaded zip file.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := Singleton.Import(args); err != nil {
-			Singleton.Log.Fatal(err)
+			Singleton.Fatal(err)
 		} else {
 			fmt.Println("Resource", args[1], "from file", args[0])
 		}
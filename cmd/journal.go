@@ -0,0 +1,577 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/rafahpe/cpcli/model"
+)
+
+const (
+	// ErrJournalCollection returned when journaling a change to a collection
+	ErrJournalCollection = Error("Only changes to single objects can be journaled, not to collections. Use a path template to change each object")
+	// ErrNoRollback returned when a journal entry can't be rolled back
+	ErrNoRollback = Error("The change can't be rolled back")
+	// ErrNoJournal returned when there are no journals to roll back
+	ErrNoJournal = Error("No journal found for the profile")
+	// ErrRollbackJournal returned when the journal to roll back is not clear
+	ErrRollbackJournal = Error("Give either the journal file to roll back, or --last")
+)
+
+// journalEntry records a change to an object, to be able to roll it back
+type journalEntry struct {
+	Time    time.Time       `json:"time"`
+	Method  model.Method    `json:"method"`
+	Path    string          `json:"path"`
+	Before  json.RawMessage `json:"before"`            // Object before the change, null if it did not exist
+	Request json.RawMessage `json:"request,omitempty"` // Body of the request, secrets masked
+	After   json.RawMessage `json:"after,omitempty"`   // Reply of the server
+	// Secret attributes of the object before the change, not saved
+	Secrets []string `json:"secrets,omitempty"`
+	// Time of the rollback, entries are only rolled back once
+	RolledBack *time.Time `json:"rolled_back,omitempty"`
+}
+
+// journalOff disables the journal, e.g. "--journal off"
+const journalOff = "off"
+
+// journalFolder returns the folder of the journals of the active profile,
+// ~/.cpcli-journal/<profile>
+func (master *Master) journalFolder() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	profile := master.Profile
+	if profile == "" {
+		profile = "default"
+	}
+	return filepath.Join(home, ".cpcli-journal", profile), nil
+}
+
+// journalFile returns the journal of the run: the configured one, or
+// a new file in the journal folder of the profile by default, named
+// after the start of the run. "" if disabled.
+func (master *Master) journalFile() string {
+	name := master.getString("journal")
+	switch name {
+	case journalOff:
+		return ""
+	case "":
+		master.journalMutex.Lock()
+		defer master.journalMutex.Unlock()
+		if master.journalRun == "" {
+			folder, err := master.journalFolder()
+			if err != nil {
+				return ""
+			}
+			// The pid tells apart the runs started in the same second
+			run := fmt.Sprintf("%s-%d.jsonl", time.Now().Format("20060102-150405"), os.Getpid())
+			master.journalRun = filepath.Join(folder, run)
+		}
+		return master.journalRun
+	}
+	return name
+}
+
+// lastJournal returns the newest journal in the folder of the profile
+func (master *Master) lastJournal() (string, error) {
+	folder, err := master.journalFolder()
+	if err != nil {
+		return "", err
+	}
+	names, err := filepath.Glob(filepath.Join(folder, "*.jsonl"))
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", ErrNoJournal
+	}
+	// Names start with the time of the run
+	sort.Strings(names)
+	return names[len(names)-1], nil
+}
+
+// journaled checks if the request must be recorded in the journal
+func (master *Master) journaled(method model.Method, query model.Params) bool {
+	if master.DryRun || master.journalFile() == "" {
+		return false
+	}
+	if method != model.POST && method != model.PUT && method != model.PATCH && method != model.DELETE {
+		return false
+	}
+	// Changes to collections can't be journaled, they run without it
+	if _, filtered := query["filter"]; filtered {
+		master.journalWarning.Do(func() {
+			master.Log.Print("Warning: changes to objects matching a filter are not saved to the journal")
+		})
+		return false
+	}
+	return true
+}
+
+// appendJournal writes the entry at the end of the journal file
+func (master *Master) appendJournal(entry journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	name := master.journalFile()
+	// Requests may run in parallel
+	master.journalMutex.Lock()
+	defer master.journalMutex.Unlock()
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = fmt.Fprintln(file, string(data)); err != nil {
+		return err
+	}
+	master.journalNotice.Do(func() {
+		master.Log.Printf("Changes saved to the journal %s, undo them with 'rollback %s'", name, name)
+	})
+	return nil
+}
+
+// notFound checks if the error is a 404 reply
+func notFound(err error) bool {
+	restErr, ok := errors.Cause(err).(model.RestError)
+	return ok && restErr.StatusCode == 404
+}
+
+// current GETs the object at the path. Returns nil if it does not exist.
+func (master *Master) current(ctx context.Context, path string) (json.RawMessage, error) {
+	reply := master.cppm.Request(model.GET, path, nil, nil)
+	if !reply.Next(ctx) {
+		if err := reply.Error(); err != nil && !notFound(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if reply.Collection() {
+		return nil, ErrJournalCollection
+	}
+	return reply.Get(), nil
+}
+
+// secretPaths returns the paths of the secret attributes of the value,
+// the ones removed by dropSecrets, e.g. "radius_secret" or "attributes.otp_secret"
+func secretPaths(prefix string, value interface{}) []string {
+	var paths []string
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if _, text := item.(string); text && model.Sensitive(key) {
+				paths = append(paths, prefix+key)
+				continue
+			}
+			paths = append(paths, secretPaths(prefix+key+".", item)...)
+		}
+	case []interface{}:
+		for i, item := range value {
+			paths = append(paths, secretPaths(fmt.Sprintf("%s%d.", prefix, i), item)...)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// journalObject returns the object to save in the journal, without its
+// secret attributes, so they are not left in plaintext on disk. Returns
+// the paths of the secrets removed too.
+func journalObject(data json.RawMessage) (json.RawMessage, []string, error) {
+	object, err := decodeObject(data)
+	if err != nil || object == nil {
+		return nil, nil, err
+	}
+	secrets := secretPaths("", object)
+	data, err = json.Marshal(dropSecrets(object))
+	return data, secrets, err
+}
+
+// journalRequest runs the request, saving the object before and after
+// the change to the journal. Returns the reply, if any.
+func (master *Master) journalRequest(ctx context.Context, method model.Method, path string, query model.Params, body interface{}) (model.RawReply, error) {
+	if _, filtered := query["filter"]; filtered {
+		return nil, ErrJournalCollection
+	}
+	// Objects created by POST did not exist before
+	var before json.RawMessage
+	if method != model.POST {
+		var err error
+		if before, err = master.current(ctx, path); err != nil {
+			return nil, errors.Wrap(err, "Error saving the object before the change")
+		}
+	}
+	feed := master.cppm.Request(method, path, query, body)
+	var after model.RawReply
+	if feed.Next(ctx) {
+		after = feed.Get()
+	}
+	if err := feed.Error(); err != nil {
+		return nil, err
+	}
+	entry := journalEntry{Time: time.Now(), Method: method, Path: path}
+	var err error
+	if entry.Before, entry.Secrets, err = journalObject(before); err != nil {
+		return after, errors.Wrap(err, "Change done, but not saved to the journal")
+	}
+	if entry.After, _, err = journalObject(after); err != nil {
+		return after, errors.Wrap(err, "Change done, but not saved to the journal")
+	}
+	if body != nil {
+		// Only the names of the attributes are needed to roll back
+		request, err := json.Marshal(body)
+		if err != nil {
+			return after, err
+		}
+		entry.Request = model.RedactBody(request)
+	}
+	if err := master.appendJournal(entry); err != nil {
+		return after, errors.Wrap(err, "Change done, but not saved to the journal")
+	}
+	return after, nil
+}
+
+// readJournal reads the entries of the journal file
+func readJournal(name string) ([]journalEntry, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries := make([]journalEntry, 0, 16)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("Line %d of %s: %s", line, name, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// markJournal sets the time of rollback of the entries, by index. The
+// file is read again, since the run may have appended entries to it.
+func (master *Master) markJournal(name string, rolledBack map[int]time.Time) error {
+	master.journalMutex.Lock()
+	defer master.journalMutex.Unlock()
+	entries, err := readJournal(name)
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	writer := bufio.NewWriter(temp)
+	for index, entry := range entries {
+		if when, ok := rolledBack[index]; ok {
+			entry.RolledBack = &when
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			temp.Close()
+			return err
+		}
+		fmt.Fprintln(writer, string(data))
+	}
+	if err := writer.Flush(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), name)
+}
+
+// decodeObject decodes a JSON object, nil if it is empty or null
+func decodeObject(data json.RawMessage) (map[string]interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var object map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	err := decoder.Decode(&object)
+	return object, err
+}
+
+// writable removes the attributes set by the server, that can't be sent back
+func writable(object map[string]interface{}) map[string]interface{} {
+	delete(object, "id")
+	delete(object, "_links")
+	return object
+}
+
+// collectionPath returns the collection of the object at the path,
+// e.g. "endpoint" for "endpoint/1234" or "endpoint/mac-address/001122334455"
+func collectionPath(path string, object map[string]interface{}) string {
+	steps := strings.Split(strings.Trim(path, "/"), "/")
+	if id, ok := object["id"]; ok && fmt.Sprint(id) == steps[len(steps)-1] {
+		return strings.Join(steps[:len(steps)-1], "/")
+	}
+	if len(steps) > 2 {
+		return strings.Join(steps[:len(steps)-2], "/")
+	}
+	return strings.Join(steps[:len(steps)-1], "/")
+}
+
+// inverse returns the request that undoes the change, and the attributes
+// that can't be restored: those added by a PATCH or PUT, that did not exist
+// before. Secrets are not in the journal, so replaced objects are patched
+// back instead of replaced, and deleted objects with secrets are not
+// created again.
+func (entry journalEntry) inverse() (model.Method, string, interface{}, []string, error) {
+	before, err := decodeObject(entry.Before)
+	if err != nil {
+		return "", "", nil, nil, err
+	}
+	switch entry.Method {
+	case model.POST:
+		// The object was created, e.g. by a rollback
+		after, err := decodeObject(entry.After)
+		if err != nil || after["id"] == nil {
+			return "", "", nil, nil, ErrNoRollback
+		}
+		return model.DELETE, fmt.Sprintf("%s/%v", strings.Trim(entry.Path, "/"), after["id"]), nil, nil, nil
+	case model.DELETE:
+		if before == nil {
+			return "", "", nil, nil, ErrNoRollback
+		}
+		if len(entry.Secrets) > 0 {
+			return "", "", nil, nil, fmt.Errorf("%s: secret attributes %s were not saved to the journal, create the object again by hand", ErrNoRollback, strings.Join(entry.Secrets, ", "))
+		}
+		return model.POST, collectionPath(entry.Path, before), writable(before), nil, nil
+	case model.PUT:
+		if before == nil {
+			// The object was created
+			return model.DELETE, entry.Path, nil, nil, nil
+		}
+		after, err := decodeObject(entry.After)
+		if err != nil {
+			return "", "", nil, nil, err
+		}
+		added := make([]string, 0, len(after))
+		for key := range after {
+			if _, ok := before[key]; !ok && key != "_links" {
+				added = append(added, key)
+			}
+		}
+		sort.Strings(added)
+		return model.PATCH, entry.Path, writable(before), added, nil
+	case model.PATCH:
+		if before == nil {
+			return "", "", nil, nil, ErrNoRollback
+		}
+		request, err := decodeObject(entry.Request)
+		if err != nil {
+			return "", "", nil, nil, err
+		}
+		// Patch back only the attributes that were changed. Sending null
+		// for the new ones would not remove them.
+		restore := make(map[string]interface{}, len(request))
+		added := make([]string, 0, len(request))
+		for key := range request {
+			if value, ok := before[key]; ok {
+				restore[key] = value
+			} else {
+				added = append(added, key)
+			}
+		}
+		sort.Strings(added)
+		if len(restore) == 0 {
+			return "", "", nil, added, ErrNoRollback
+		}
+		return model.PATCH, entry.Path, restore, added, nil
+	}
+	return "", "", nil, nil, ErrNoRollback
+}
+
+// splitSecrets splits the names of attributes into the secret ones,
+// and the rest
+func splitSecrets(names []string) (secrets, others []string) {
+	for _, name := range names {
+		if model.Sensitive(name) {
+			secrets = append(secrets, name)
+		} else {
+			others = append(others, name)
+		}
+	}
+	return secrets, others
+}
+
+// sameObject compares two versions of an object, ignoring links.
+// If the reply of the change was empty, it can't be compared and
+// the object is assumed not to be changed.
+func sameObject(current, after json.RawMessage, method model.Method) bool {
+	if method != model.DELETE && len(after) == 0 {
+		return true
+	}
+	currentObject, err := decodeObject(current)
+	if err != nil {
+		return false
+	}
+	afterObject, err := decodeObject(after)
+	if err != nil {
+		return false
+	}
+	// Secrets are not saved to the journal
+	if currentObject != nil {
+		delete(currentObject, "_links")
+		dropSecrets(currentObject)
+	}
+	if afterObject != nil {
+		delete(afterObject, "_links")
+	}
+	return reflect.DeepEqual(currentObject, afterObject)
+}
+
+// sameFile checks if both names refer to the same file
+func sameFile(name, other string) bool {
+	if name == other {
+		return true
+	}
+	left, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+	right, err := os.Stat(other)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(left, right)
+}
+
+// Rollback undoes the changes in the journal not rolled back yet, in
+// reverse order. Objects changed since are reported and skipped, unless
+// force. The requests of the rollback are journaled too, unless to the same
+// journal, and the entries rolled back are marked in the journal.
+func (master *Master) Rollback(name string, force bool) error {
+	entries, err := readJournal(name)
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, entry := range entries {
+		if entry.RolledBack == nil {
+			pending++
+		}
+	}
+	if pending == 0 {
+		master.Log.Printf("All the changes in %s were rolled back already", name)
+		return nil
+	}
+	if err := master.ask(fmt.Sprintf("Roll back %d changes from %s?", pending, name)); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	// The rollback is not journaled to the journal rolled back: its entries
+	// would be pending there, and undo the rollback the next time.
+	journaled := master.journaled(model.PUT, nil)
+	if journaled && sameFile(master.journalFile(), name) {
+		journaled = false
+		master.Log.Printf("The rollback is not journaled, it would be saved to %s itself", name)
+	}
+	rolledBack := make(map[int]time.Time, pending)
+	succeeded, failed := 0, 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.RolledBack != nil {
+			continue
+		}
+		what := fmt.Sprintf("%s %s at %s", entry.Method, entry.Path, entry.Time.Format(time.RFC3339))
+		method, path, body, added, err := entry.inverse()
+		secrets, others := splitSecrets(added)
+		if len(others) > 0 {
+			master.Log.Printf("%s: attributes %s did not exist before, they can't be restored", what, strings.Join(others, ", "))
+		}
+		if len(secrets) > 0 {
+			master.Log.Printf("%s: secret attributes %s are not saved to the journal, they can't be restored", what, strings.Join(secrets, ", "))
+		}
+		if err != nil {
+			failed++
+			master.report(errors.Wrap(err, what), 0)
+			continue
+		}
+		if master.DryRun {
+			if err := master.dryRun(os.Stdout, method, path, nil, body); err != nil {
+				return err
+			}
+			continue
+		}
+		// Objects created by POST are checked at their own path
+		target := entry.Path
+		if entry.Method == model.POST {
+			target = path
+		}
+		current, err := master.current(ctx, target)
+		if err != nil {
+			failed++
+			master.report(errors.Wrap(err, what), 0)
+			continue
+		}
+		if !force && !sameObject(current, entry.After, entry.Method) {
+			failed++
+			master.Log.Printf("%s: object changed since, skipped (use --force to roll back anyway)", what)
+			continue
+		}
+		var reply model.RawReply
+		if journaled {
+			reply, err = master.journalRequest(ctx, method, path, nil, body)
+		} else {
+			feed := master.cppm.Request(method, path, nil, body)
+			for feed.Next(ctx) {
+				reply = feed.Get()
+			}
+			err = feed.Error()
+		}
+		if err != nil {
+			failed++
+			master.report(errors.Wrap(err, what), 0)
+			continue
+		}
+		if method == model.POST {
+			if restored, err := decodeObject(reply); err == nil && restored["id"] != nil {
+				master.Log.Printf("%s: restored as %s/%v", what, path, restored["id"])
+			}
+		}
+		rolledBack[i] = time.Now()
+		succeeded++
+	}
+	if master.DryRun {
+		return nil
+	}
+	if len(rolledBack) > 0 {
+		if err := master.markJournal(name, rolledBack); err != nil {
+			master.report(errors.Wrap(err, "Changes rolled back, but not marked in the journal"), 0)
+		}
+	}
+	master.summary(succeeded, failed)
+	if failed > 0 {
+		return BulkError{Succeeded: succeeded, Failed: failed}
+	}
+	return nil
+}
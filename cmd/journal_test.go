@@ -0,0 +1,338 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/rafahpe/cpcli/model"
+	"github.com/spf13/viper"
+)
+
+func TestCollectionPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		object map[string]interface{}
+		want   string
+	}{
+		{"endpoint/1234", map[string]interface{}{"id": json.Number("1234")}, "endpoint"},
+		{"/endpoint/1234/", map[string]interface{}{"id": json.Number("1234")}, "endpoint"},
+		{"endpoint/mac-address/001122334455", map[string]interface{}{"id": json.Number("7")}, "endpoint"},
+		{"local-user/user-id/bob", map[string]interface{}{"id": json.Number("3")}, "local-user"},
+		{"network-device/5", map[string]interface{}{}, "network-device"},
+	}
+	for _, test := range tests {
+		if got := collectionPath(test.path, test.object); got != test.want {
+			t.Errorf("collectionPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestInverse(t *testing.T) {
+	before := `{"id":12,"name":"r1","description":"old","_links":{"self":{"href":"x"}}}`
+	tests := []struct {
+		name   string
+		entry  journalEntry
+		method model.Method
+		path   string
+		body   string
+		added  []string
+		fails  bool
+	}{
+		{"delete", journalEntry{Method: model.DELETE, Path: "role/12", Before: json.RawMessage(before)},
+			model.POST, "role", `{"description":"old","name":"r1"}`, nil, false},
+		{"delete missing", journalEntry{Method: model.DELETE, Path: "role/12", Before: json.RawMessage(`null`)},
+			"", "", ``, nil, true},
+		{"delete with secrets", journalEntry{Method: model.DELETE, Path: "network-device/12", Before: json.RawMessage(before), Secrets: []string{"radius_secret"}},
+			"", "", ``, nil, true},
+		{"put replaced", journalEntry{Method: model.PUT, Path: "role/12", Before: json.RawMessage(before)},
+			model.PATCH, "role/12", `{"description":"old","name":"r1"}`, nil, false},
+		{"put adds", journalEntry{Method: model.PUT, Path: "role/12", Before: json.RawMessage(before), After: json.RawMessage(`{"id":12,"name":"r1","color":"red","_links":{}}`)},
+			model.PATCH, "role/12", `{"description":"old","name":"r1"}`, []string{"color"}, false},
+		{"put created", journalEntry{Method: model.PUT, Path: "role/12", Before: json.RawMessage(`null`)},
+			model.DELETE, "role/12", `null`, nil, false},
+		{"patch", journalEntry{Method: model.PATCH, Path: "role/12", Before: json.RawMessage(before), Request: json.RawMessage(`{"description":"new"}`)},
+			model.PATCH, "role/12", `{"description":"old"}`, nil, false},
+		{"patch adds", journalEntry{Method: model.PATCH, Path: "role/12", Before: json.RawMessage(before), Request: json.RawMessage(`{"description":"new","tags":["a"],"color":"red"}`)},
+			model.PATCH, "role/12", `{"description":"old"}`, []string{"color", "tags"}, false},
+		{"patch only adds", journalEntry{Method: model.PATCH, Path: "role/12", Before: json.RawMessage(before), Request: json.RawMessage(`{"tags":["a"]}`)},
+			"", "", ``, []string{"tags"}, true},
+		{"post", journalEntry{Method: model.POST, Path: "role", Before: json.RawMessage(`null`), After: json.RawMessage(`{"id":13,"name":"r1"}`)},
+			model.DELETE, "role/13", `null`, nil, false},
+		{"post without reply", journalEntry{Method: model.POST, Path: "role", Before: json.RawMessage(`null`)},
+			"", "", ``, nil, true},
+	}
+	for _, test := range tests {
+		method, path, body, added, err := test.entry.inverse()
+		if (err != nil) != test.fails {
+			t.Errorf("%s: error = %v", test.name, err)
+			continue
+		}
+		if len(added) > 0 || len(test.added) > 0 {
+			if !reflect.DeepEqual(added, test.added) {
+				t.Errorf("%s: added = %v, want %v", test.name, added, test.added)
+			}
+		}
+		if test.fails {
+			continue
+		}
+		data, _ := json.Marshal(body)
+		if method != test.method || path != test.path || string(data) != test.body {
+			t.Errorf("%s: got %s %s %s, want %s %s %s", test.name, method, path, data, test.method, test.path, test.body)
+		}
+	}
+}
+
+func TestSameObject(t *testing.T) {
+	tests := []struct {
+		current, after string
+		method         model.Method
+		want           bool
+	}{
+		{`{"id":1,"name":"a","_links":{"self":1}}`, `{"id":1,"name":"a"}`, model.PATCH, true},
+		{`{"id":1,"name":"b"}`, `{"id":1,"name":"a"}`, model.PATCH, false},
+		{`{"id":1,"name":"b"}`, ``, model.PUT, true},
+		{``, ``, model.DELETE, true},
+		{`{"id":1}`, ``, model.DELETE, false},
+	}
+	for _, test := range tests {
+		if got := sameObject(json.RawMessage(test.current), json.RawMessage(test.after), test.method); got != test.want {
+			t.Errorf("sameObject(%s, %s, %s) = %v, want %v", test.current, test.after, test.method, got, test.want)
+		}
+	}
+}
+
+func TestRollbackForceFlag(t *testing.T) {
+	if err := rollbackCmd.ParseFlags([]string{"-F"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { rollbackForce = false }()
+	if !rollbackForce || Singleton.Force {
+		t.Errorf("rollback -F set rollbackForce = %v, root Force = %v", rollbackForce, Singleton.Force)
+	}
+}
+
+// testHome sets a temporary home folder, for the default journals
+func testHome(t *testing.T) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	homedir.DisableCache = true
+	t.Cleanup(func() { homedir.DisableCache = false })
+	return home
+}
+
+func TestJournalFile(t *testing.T) {
+	home := testHome(t)
+	tests := []struct {
+		profile string
+		journal string
+		folder  string // Folder of the default journal, "" if not default
+		want    string
+	}{
+		{"", "", filepath.Join(home, ".cpcli-journal", "default"), ""},
+		{"lab", "", filepath.Join(home, ".cpcli-journal", "lab"), ""},
+		{"lab", "off", "", ""},
+		{"lab", "changes.jsonl", "", "changes.jsonl"},
+	}
+	for _, test := range tests {
+		viper.Reset()
+		viper.Set("journal", test.journal)
+		master := &Master{Profile: test.profile}
+		got := master.journalFile()
+		if test.folder == "" {
+			if got != test.want {
+				t.Errorf("journal %q: got %q, want %q", test.journal, got, test.want)
+			}
+			continue
+		}
+		if filepath.Dir(got) != test.folder || filepath.Ext(got) != ".jsonl" {
+			t.Errorf("profile %q: got %q, want a file in %s", test.profile, got, test.folder)
+		}
+		if again := master.journalFile(); again != got {
+			t.Errorf("profile %q: journal changed in the run from %q to %q", test.profile, got, again)
+		}
+	}
+	viper.Reset()
+}
+
+func TestLastJournal(t *testing.T) {
+	home := testHome(t)
+	master := &Master{Profile: "lab"}
+	if _, err := master.lastJournal(); err != ErrNoJournal {
+		t.Errorf("got %v, want %v", err, ErrNoJournal)
+	}
+	folder := filepath.Join(home, ".cpcli-journal", "lab")
+	os.MkdirAll(folder, 0700)
+	for _, name := range []string{"20261016-101500-7.jsonl", "20261016-091500-9.jsonl", "notes.txt"} {
+		ioutil.WriteFile(filepath.Join(folder, name), nil, 0600)
+	}
+	want := filepath.Join(folder, "20261016-101500-7.jsonl")
+	if got, err := master.lastJournal(); err != nil || got != want {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	testHome(t)
+	// The object was patched from "a" to "b"
+	var mutex sync.Mutex
+	object := map[string]interface{}{"id": 1, "name": "b"}
+	patches := 0
+	master := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "PATCH" {
+			patches++
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			for key, value := range body {
+				object[key] = value
+			}
+		}
+		json.NewEncoder(w).Encode(object)
+	}, "token", time.Time{})
+	logs := &bytes.Buffer{}
+	master.Log = log.New(logs, "", 0)
+	master.Yes = true
+	name := filepath.Join(t.TempDir(), "changes.jsonl")
+	entry := journalEntry{
+		Time:    time.Now(),
+		Method:  model.PATCH,
+		Path:    "endpoint/1",
+		Before:  json.RawMessage(`{"id":1,"name":"a"}`),
+		Request: json.RawMessage(`{"name":"b"}`),
+		After:   json.RawMessage(`{"id":1,"name":"b"}`),
+	}
+	data, _ := json.Marshal(entry)
+	if err := ioutil.WriteFile(name, append(data, '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := master.Rollback(name, false); err != nil {
+		t.Fatal(err)
+	}
+	if patches != 1 || object["name"] != "a" {
+		t.Fatalf("got %d patches, object %v, want it rolled back", patches, object)
+	}
+	entries, err := readJournal(name)
+	if err != nil || len(entries) != 1 || entries[0].RolledBack == nil {
+		t.Fatalf("journal entries %+v, %v, want the entry marked", entries, err)
+	}
+	// The rollback is journaled in the journal of the run
+	rollback, err := readJournal(master.journalFile())
+	if err != nil || len(rollback) != 1 || rollback[0].Method != model.PATCH || string(rollback[0].Before) != `{"id":1,"name":"b"}` {
+		t.Fatalf("rollback journal %+v, %v, want the inverse request", rollback, err)
+	}
+	if !strings.Contains(logs.String(), master.journalFile()) {
+		t.Errorf("journal path not printed in %q", logs.String())
+	}
+	// Rolling back again does nothing
+	if err := master.Rollback(name, false); err != nil {
+		t.Fatal(err)
+	}
+	if patches != 1 {
+		t.Errorf("rolled back twice, got %d patches", patches)
+	}
+	if !strings.Contains(logs.String(), "rolled back already") {
+		t.Errorf("second rollback not reported in %q", logs.String())
+	}
+}
+
+func TestJournalSecrets(t *testing.T) {
+	master := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1,"user_id":"bob","enabled":false,"password":"hunter2","attributes":{"otp_secret":"abc"}}`))
+	}, "token", time.Time{})
+	name := filepath.Join(t.TempDir(), "changes.jsonl")
+	viper.Set("journal", name)
+	body := map[string]interface{}{"password": "s3cret", "enabled": true}
+	if _, err := master.journalRequest(context.Background(), model.PATCH, "local-user/1", nil, body); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "s3cret", "abc"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("secret %q saved to the journal: %s", secret, data)
+		}
+	}
+	entries, err := readJournal(name)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("journal entries %+v, %v, want one", entries, err)
+	}
+	if want := []string{"attributes.otp_secret", "password"}; !reflect.DeepEqual(entries[0].Secrets, want) {
+		t.Errorf("secrets = %v, want %v", entries[0].Secrets, want)
+	}
+	// The request keeps the names of the attributes, to roll them back
+	_, _, restore, added, err := entries[0].inverse()
+	data, _ = json.Marshal(restore)
+	if err != nil || string(data) != `{"enabled":false}` || !reflect.DeepEqual(added, []string{"password"}) {
+		t.Errorf("inverse got %s, %v, %v, want enabled restored and password not", data, added, err)
+	}
+}
+
+func TestRollbackSingleJournal(t *testing.T) {
+	object := map[string]interface{}{"id": 1, "name": "b"}
+	patches := 0
+	master := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == "PATCH" {
+			patches++
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			for key, value := range body {
+				object[key] = value
+			}
+		}
+		json.NewEncoder(w).Encode(object)
+	}, "token", time.Time{})
+	master.Yes = true
+	// The journal is a single file, set in the config
+	name := filepath.Join(t.TempDir(), "changes.jsonl")
+	viper.Set("journal", name)
+	if _, err := master.journalRequest(context.Background(), model.PATCH, "endpoint/1", nil, map[string]interface{}{"name": "c"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := master.Rollback(name, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if patches != 2 || object["name"] != "b" {
+		t.Errorf("got %d patches, object %v, want it rolled back once", patches, object)
+	}
+	entries, err := readJournal(name)
+	if err != nil || len(entries) != 1 || entries[0].RolledBack == nil {
+		t.Errorf("journal entries %+v, %v, want the entry marked", entries, err)
+	}
+}
+
+func TestJournaledFilter(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("journal", filepath.Join(t.TempDir(), "changes.jsonl"))
+	logs := &bytes.Buffer{}
+	master := &Master{Log: log.New(logs, "", 0)}
+	if master.journaled(model.PATCH, model.Params{"filter": "{}"}) {
+		t.Error("filtered PATCH journaled, want it run without the journal")
+	}
+	if !strings.Contains(logs.String(), "not saved to the journal") {
+		t.Errorf("no warning in %q", logs.String())
+	}
+	if !master.journaled(model.PATCH, nil) {
+		t.Error("PATCH not journaled")
+	}
+}
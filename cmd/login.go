@@ -15,6 +15,8 @@
 package cmd
 
 import (
+	"github.com/pkg/errors"
+	"github.com/rafahpe/cpcli/model"
 	"github.com/spf13/cobra"
 	// Using this forst instead of the original because of write file support
 	// See: https://github.com/spf13/viper/pull/287
@@ -32,17 +34,21 @@ var loginCmd = &cobra.Command{
   - The OAUTH token can be provided in the 'token' configuration variable, the CPPM_TOKEN environment variable, or the -t flag.
   - If you have an OAUTH refresh token, it can be provided in the 'refresh' configuration variable, the CPPM_REFRESH environment variable, or the -r flag.
   - If OAUTH token is missing, invalid or expired, then client_id can be provided in the 'client' config variable, CPPM_CLIENT environment variable, or -c flag.
-  - If you are using username/password based auth, besides the client ID, you will need to provide your username with the 'user' config variable, CPPM_USER environment variable, or -u flag`,
+  - If you are using username/password based auth, besides the client ID, you will need to provide your username with the 'user' config variable, CPPM_USER environment variable, or -u flag
+  - The token expiration time is saved in the 'expires' config variable. When the token is about to expire, or the server rejects it, it is renewed using the refresh token and saved again.
+  - When a profile is selected with the -C flag or CPPM_PROFILE environment variable, settings are read from and saved to that profile in the 'profiles' section of the config file.
+  - Tokens and cookies are kept in the credential backend selected with the 'secrets' config variable or --secrets flag: 'keyring' (OS secret store, default), 'file' (encrypted with the passphrase in CPPM_PASSPHRASE, or prompted) or 'plain' (config file, readable only by the owner). Keyring items are named after the profile, server and client, so config files with the same profiles don't share tokens.
+  - Client secret and password are read from the CPPM_SECRET and CPPM_PASSWORD environment variables, the --secret-file or --secret-stdin flags ('secret=...' and 'password=...' lines), or the command in the 'credential-helper' config variable (git-credential style). If none of them is provided, they are prompted.`,
 	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Log to Stderr without timestamps
 		if token, refresh, err := Singleton.Login(); err != nil {
-			Singleton.Log.Fatal("Login error: ", err)
+			Singleton.Fatal(errors.Wrap(err, "Login error"))
 		} else {
 			if err := Singleton.Save(token, refresh); err != nil {
-				Singleton.Log.Fatal("login Error saving config data: ", err)
+				Singleton.Fatal(errors.Wrap(err, "login Error saving config data"))
 			}
-			Singleton.Log.Println("login OK. Authorization: Bearer ", token)
+			Singleton.Log.Println("login OK. Authorization: Bearer ", model.Mask(token))
 		}
 	},
 }
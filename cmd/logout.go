@@ -0,0 +1,47 @@
+// Copyright © 2017 Rafael Rivero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rafahpe/cpcli/model"
+	"github.com/spf13/cobra"
+)
+
+// logoutCmd represents the logout command
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Log out from the CPPM API",
+	Long: `Logs out from the CPPM API.
+
+  - Revokes the OAUTH token and refresh token in the server, if supported.
+  - Removes them from the credentials of the active profile, even if revocation failed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := Singleton.Logout()
+		if logoutErr, ok := err.(LogoutError); ok && logoutErr.Err == model.ErrRevokeUnsupported {
+			Singleton.Log.Print("Local credentials removed. ", model.ErrRevokeUnsupported)
+			return
+		}
+		if err != nil {
+			Singleton.Fatal(errors.Wrap(err, "Logout error"))
+		}
+		Singleton.Log.Print("Logout completed, tokens revoked")
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(logoutCmd)
+}
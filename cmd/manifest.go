@@ -0,0 +1,470 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rafahpe/cpcli/model"
+	"github.com/rafahpe/cpcli/term"
+)
+
+const (
+	// ErrMissingManifest returned when no manifest is provided for apply
+	ErrMissingManifest = Error("No manifest specified, use -f <file or folder>")
+	// ErrManifestType returned when a manifest does not tell the type of its items
+	ErrManifestType = Error("Manifest without 'type'")
+)
+
+// manifest declares the desired state of some objects of a resource
+type manifest struct {
+	Type  string                   `json:"type"`
+	Path  string                   `json:"path,omitempty"` // Overrides the REST collection of the type
+	Key   string                   `json:"key,omitempty"`  // Overrides the natural key of the type
+	Items []map[string]interface{} `json:"items"`
+}
+
+// desiredState of the objects of a resource, by natural key
+type desiredState struct {
+	resource
+	objects map[string]map[string]interface{}
+}
+
+// Actions of the plan, as printed
+const (
+	actionCreate = "+"
+	actionUpdate = "~"
+	actionDelete = "-"
+)
+
+// change is a step of the plan
+type change struct {
+	action   string
+	resource resource
+	key      string
+	path     string                 // Path of the request, the collection or the object
+	body     map[string]interface{} // Object to create, or attributes to update
+	current  map[string]interface{} // Object in the server, if any
+}
+
+// manifestFiles lists the files, and the manifests inside the folders
+func manifestFiles(names []string) ([]string, error) {
+	files := make([]string, 0, len(names))
+	for _, name := range names {
+		if name == "-" {
+			files = append(files, name)
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, name)
+			continue
+		}
+		entries, err := ioutil.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".yaml", ".yml", ".json", ".hjson":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(name, entry.Name()))
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// readManifests reads the manifests in the files or folders, and merges
+// them by type. Known types are returned in dependency order, followed
+// by the rest in order of appearance.
+func readManifests(names []string, format string) ([]*desiredState, error) {
+	files, err := manifestFiles(names)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]*desiredState)
+	unknown := make([]string, 0, 4)
+	for _, file := range files {
+		input, err := term.Data("@"+file, format)
+		if err != nil {
+			return nil, errors.Wrap(err, file)
+		}
+		for input.Next() {
+			var m manifest
+			decoder := json.NewDecoder(strings.NewReader(string(input.Get())))
+			decoder.UseNumber()
+			if err := decoder.Decode(&m); err != nil {
+				return nil, errors.Wrap(err, file)
+			}
+			if m.Type == "" {
+				return nil, errors.Wrap(ErrManifestType, file)
+			}
+			r, known := findResource(m.Type)
+			if r.Single {
+				return nil, fmt.Errorf("%s: '%s' are settings, not a collection, they can't be applied", file, m.Type)
+			}
+			if !known {
+				r = resource{Type: m.Type, Path: m.Type}
+			}
+			if m.Path != "" {
+				r.Path = m.Path
+			}
+			if m.Key != "" {
+				r.Key = m.Key
+			}
+			if r.Key == "" {
+				return nil, fmt.Errorf("%s: unknown type '%s' needs a 'key', or use one of %s", file, m.Type, strings.Join(resourceTypes(), ", "))
+			}
+			state, ok := states[m.Type]
+			if !ok {
+				state = &desiredState{resource: r, objects: make(map[string]map[string]interface{})}
+				states[m.Type] = state
+				if !known {
+					unknown = append(unknown, m.Type)
+				}
+			} else if state.resource != r {
+				return nil, fmt.Errorf("%s: conflicting path or key for type '%s'", file, m.Type)
+			}
+			for _, item := range m.Items {
+				key, ok := naturalKey(item, r.Key)
+				if !ok {
+					return nil, fmt.Errorf("%s: %s without '%s'", file, r.Type, r.Key)
+				}
+				if _, dup := state.objects[key]; dup {
+					return nil, fmt.Errorf("%s: duplicate %s '%s'", file, r.Type, key)
+				}
+				state.objects[key] = item
+			}
+		}
+		if err := input.Error(); err != nil {
+			return nil, errors.Wrap(err, file)
+		}
+	}
+	result := make([]*desiredState, 0, len(states))
+	for _, r := range resources {
+		if state, ok := states[r.Type]; ok {
+			result = append(result, state)
+		}
+	}
+	for _, name := range unknown {
+		result = append(result, states[name])
+	}
+	return result, nil
+}
+
+// collection GETs all the objects in the path
+func collection(ctx context.Context, cppm model.Clearpass, path string) ([]map[string]interface{}, error) {
+	feed := cppm.Request(model.GET, path, model.Params{"limit": "1000"}, nil)
+	items := make([]map[string]interface{}, 0, 64)
+	for feed.Next(ctx) {
+		item, err := decodeObject(feed.Get())
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, feed.Error()
+}
+
+// sortedKeys returns the keys of the map, sorted
+func sortedKeys(objects map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// objectPath returns the path of an object in the server, by id
+func objectPath(r resource, key string, object map[string]interface{}) (string, error) {
+	id, ok := object["id"]
+	if !ok || id == nil {
+		return "", fmt.Errorf("%s '%s' has no id", r.Type, key)
+	}
+	return r.Path + "/" + fmt.Sprint(id), nil
+}
+
+// matchValue checks if the current value matches the desired one.
+// Objects match if they have the desired attributes, and maybe more.
+func matchValue(want, have interface{}) bool {
+	switch want := want.(type) {
+	case map[string]interface{}:
+		have, ok := have.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			if !matchValue(value, have[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		have, ok := have.([]interface{})
+		if !ok || len(have) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !matchValue(want[i], have[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		// Same number in different notation, e.g. "1" and "1.0"
+		if have, ok := have.(json.Number); ok && want != have {
+			a, errA := want.Float64()
+			b, errB := have.Float64()
+			return errA == nil && errB == nil && a == b
+		}
+	}
+	return reflect.DeepEqual(want, have)
+}
+
+// changedAttributes returns the attributes of the desired object that
+// differ from the current one. Attributes not in the manifest are not
+// managed, and secrets the server does not return are only sent on create.
+func changedAttributes(want, have map[string]interface{}) map[string]interface{} {
+	changed := make(map[string]interface{})
+	for key, value := range want {
+		current, found := have[key]
+		if !found && model.Sensitive(key) {
+			continue
+		}
+		if !found || !matchValue(value, current) {
+			changed[key] = value
+		}
+	}
+	return changed
+}
+
+// plan compares the desired state with the server, and returns the
+// changes to converge. With prune, objects not in the manifests are
+// deleted, except the ones predefined by the server and the API client
+// cpcli is logged in with.
+func (master *Master) plan(ctx context.Context, states []*desiredState, prune bool) ([]change, error) {
+	changes := make([]change, 0, 16)
+	deletes := make([][]change, 0, len(states))
+	for _, state := range states {
+		items, err := collection(ctx, master.cppm, state.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error reading %s", state.Path)
+		}
+		current := make(map[string]map[string]interface{}, len(items))
+		for _, item := range items {
+			if key, ok := naturalKey(item, state.Key); ok {
+				current[key] = item
+			}
+		}
+		for _, key := range sortedKeys(state.objects) {
+			want := state.objects[key]
+			have, found := current[key]
+			if !found {
+				changes = append(changes, change{action: actionCreate, resource: state.resource, key: key, path: state.Path, body: want})
+				continue
+			}
+			changed := changedAttributes(want, have)
+			// The key matched, maybe in another format
+			delete(changed, state.Key)
+			if len(changed) == 0 {
+				continue
+			}
+			path, err := objectPath(state.resource, key, have)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, change{action: actionUpdate, resource: state.resource, key: key, path: path, body: changed, current: have})
+		}
+		if !prune {
+			continue
+		}
+		removed := make([]change, 0, 4)
+		kept := 0
+		for _, key := range sortedKeys(current) {
+			if _, ok := state.objects[key]; ok {
+				continue
+			}
+			if builtIn(current[key]) {
+				kept++
+				continue
+			}
+			if state.Path == "api-client" && key == master.getString("client") {
+				master.Log.Printf("api-client '%s' not pruned, cpcli is logged in with it", key)
+				continue
+			}
+			path, err := objectPath(state.resource, key, current[key])
+			if err != nil {
+				return nil, err
+			}
+			removed = append(removed, change{action: actionDelete, resource: state.resource, key: key, path: path, current: current[key]})
+		}
+		if kept > 0 {
+			master.Log.Printf("%d predefined %s objects not pruned", kept, state.Type)
+		}
+		deletes = append(deletes, removed)
+	}
+	// Objects are deleted after the rest of changes, in reverse dependency order
+	for i := len(deletes) - 1; i >= 0; i-- {
+		changes = append(changes, deletes[i]...)
+	}
+	return changes, nil
+}
+
+// planValue formats the value of an attribute for the plan, with secrets masked
+func planValue(key string, value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	if model.Sensitive(key) {
+		return model.Mask(string(data))
+	}
+	return string(model.RedactBody(data))
+}
+
+// printPlan writes the changes, terraform style
+func printPlan(w io.Writer, changes []change) {
+	counts := make(map[string]int, 3)
+	for _, c := range changes {
+		counts[c.action]++
+		fmt.Fprintf(w, "%s %s \"%s\"\n", c.action, c.resource.Type, c.key)
+		keys := make([]string, 0, len(c.body))
+		for key := range c.body {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if c.action == actionUpdate {
+				fmt.Fprintf(w, "    %s: %s => %s\n", key, planValue(key, c.current[key]), planValue(key, c.body[key]))
+			} else {
+				fmt.Fprintf(w, "    %s: %s\n", key, planValue(key, c.body[key]))
+			}
+		}
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to delete.\n", counts[actionCreate], counts[actionUpdate], counts[actionDelete])
+}
+
+// execute sends a change to the server, journaled if enabled
+func (master *Master) execute(ctx context.Context, method model.Method, path string, body interface{}) error {
+	if master.journaled(method, nil) {
+		_, err := master.journalRequest(ctx, method, path, nil, body)
+		return err
+	}
+	feed := master.cppm.Request(method, path, nil, body)
+	for feed.Next(ctx) {
+	}
+	return feed.Error()
+}
+
+// confirmPrune asks before deleting objects of guarded types, once for
+// each type. Deletions not confirmed are dropped from the changes.
+func (master *Master) confirmPrune(changes []change) ([]change, error) {
+	counts := make(map[string]int)
+	types := make([]string, 0, 4)
+	for _, c := range changes {
+		if c.action != actionDelete || !c.resource.Guarded {
+			continue
+		}
+		if counts[c.resource.Type] == 0 {
+			types = append(types, c.resource.Type)
+		}
+		counts[c.resource.Type]++
+	}
+	declined := make(map[string]bool, len(types))
+	for _, name := range types {
+		err := master.ask(fmt.Sprintf("Prune %d %s objects not in the manifests?", counts[name], name))
+		if err == ErrNotConfirmed {
+			master.Log.Printf("%s objects not pruned", name)
+			declined[name] = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(declined) == 0 {
+		return changes, nil
+	}
+	kept := make([]change, 0, len(changes))
+	for _, c := range changes {
+		if c.action != actionDelete || !declined[c.resource.Type] {
+			kept = append(kept, c)
+		}
+	}
+	return kept, nil
+}
+
+// Apply converges the server onto the manifests in the files or folders.
+// Prints the plan, and runs it after confirmation (or --yes).
+func (master *Master) Apply(names []string, prune bool) error {
+	states, err := readManifests(names, master.InputFormat)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	changes, err := master.plan(ctx, states, prune)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Println("No changes.")
+		return nil
+	}
+	printPlan(os.Stdout, changes)
+	if changes, err = master.confirmPrune(changes); err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := master.ask("Apply the plan?"); err != nil {
+		return err
+	}
+	succeeded, failed := 0, 0
+	for _, c := range changes {
+		var method model.Method
+		var body interface{}
+		switch c.action {
+		case actionCreate:
+			method, body = model.POST, c.body
+		case actionUpdate:
+			method, body = model.PATCH, c.body
+		case actionDelete:
+			method = model.DELETE
+		}
+		if master.DryRun {
+			if err := master.dryRun(os.Stdout, method, c.path, nil, body); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := master.execute(ctx, method, c.path, body); err != nil {
+			failed++
+			master.report(errors.Wrapf(err, "%s %s '%s'", c.action, c.resource.Type, c.key), 0)
+			continue
+		}
+		succeeded++
+	}
+	if master.DryRun {
+		return nil
+	}
+	master.summary(succeeded, failed)
+	if failed > 0 {
+		return BulkError{Succeeded: succeeded, Failed: failed}
+	}
+	return nil
+}
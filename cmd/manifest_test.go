@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rafahpe/cpcli/model"
+)
+
+func TestMatchValue(t *testing.T) {
+	tests := []struct {
+		want, have string
+		match      bool
+	}{
+		{`"a"`, `"a"`, true},
+		{`"a"`, `"b"`, false},
+		{`1`, `1.0`, true},
+		{`1`, `"1"`, false},
+		{`{"a":1}`, `{"a":1,"b":2}`, true},
+		{`{"a":1,"b":2}`, `{"a":1}`, false},
+		{`{"a":{"b":true}}`, `{"a":{"b":true,"c":null}}`, true},
+		{`[1,2]`, `[1,2]`, true},
+		{`[1,2]`, `[2,1]`, false},
+		{`[{"a":1}]`, `[{"a":1,"id":3}]`, true},
+		{`null`, `null`, true},
+		{`null`, `0`, false},
+	}
+	for _, test := range tests {
+		if got := matchValue(decodeValue(t, test.want), decodeValue(t, test.have)); got != test.match {
+			t.Errorf("matchValue(%s, %s) = %v, want %v", test.want, test.have, got, test.match)
+		}
+	}
+}
+
+// decodeValue decodes a JSON value the way manifests and replies are read
+func decodeValue(t *testing.T, data string) interface{} {
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		t.Fatalf("%s: %s", data, err)
+	}
+	return value
+}
+
+func TestChangedAttributes(t *testing.T) {
+	want := map[string]interface{}{"name": "a", "description": "new", "radius_secret": "s", "vendor": "Aruba"}
+	have := map[string]interface{}{"id": json.Number("1"), "name": "a", "description": "old", "vendor": "Aruba"}
+	got := changedAttributes(want, have)
+	if len(got) != 1 || got["description"] != "new" {
+		t.Errorf("changedAttributes() = %v, want only the description", got)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	collections := map[string]string{
+		"/api/role":           `[{"id":1,"name":"[Guest]"},{"id":2,"name":"old"},{"id":3,"name":"keep","description":"a"}]`,
+		"/api/network-device": `[{"id":5,"name":"sw","ip_address":"10.0.0.1"},{"id":6,"name":"sw","ip_address":"10.0.0.2"}]`,
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		items, ok := collections[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"_embedded":{"items":%s},"_links":{}}`, items)
+	}))
+	defer server.Close()
+	role, _ := findResource("role")
+	device, _ := findResource("network-device")
+	states := []*desiredState{
+		{resource: role, objects: map[string]map[string]interface{}{
+			"keep": {"name": "keep", "description": "b"},
+			"new":  {"name": "new"},
+		}},
+		{resource: device, objects: map[string]map[string]interface{}{
+			"10.0.0.1": {"name": "core", "ip_address": "10.0.0.1"},
+			"10.0.0.2": {"name": "sw", "ip_address": "10.0.0.2"},
+		}},
+	}
+	tests := []struct {
+		prune bool
+		want  []string
+	}{
+		{false, []string{"~ role/3 keep", "+ role new", "~ network-device/5 10.0.0.1"}},
+		{true, []string{"~ role/3 keep", "+ role new", "~ network-device/5 10.0.0.1", "- role/2 old"}},
+	}
+	for _, test := range tests {
+		logs := &bytes.Buffer{}
+		master := &Master{Log: log.New(logs, "", 0)}
+		master.cppm = model.New(strings.TrimPrefix(server.URL, "https://"), "cpcli", "token", "", time.Time{}, nil, true)
+		master.cppm.SetRetry(model.RetryPolicy{})
+		changes, err := master.plan(context.Background(), states, test.prune)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(changes))
+		for _, c := range changes {
+			got = append(got, fmt.Sprintf("%s %s %s", c.action, c.path, c.key))
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("plan(prune=%v) = %q, want %q", test.prune, got, test.want)
+		}
+		if test.prune && !strings.Contains(logs.String(), "1 predefined role") {
+			t.Errorf("plan(prune) did not report the predefined role: %q", logs.String())
+		}
+	}
+}
+
+func TestBuiltIn(t *testing.T) {
+	tests := map[string]bool{"[Guest]": true, "[Policy Manager Admin Network Login Service]": true, "Guest": false, "[Guest": false, "": false}
+	for name, want := range tests {
+		if got := builtIn(map[string]interface{}{"name": name}); got != want {
+			t.Errorf("builtIn(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestPlanKeepsClient(t *testing.T) {
+	master := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"_embedded":{"items":[{"id":1,"client_id":"cpcli"},{"id":2,"client_id":"old"}]},"_links":{}}`)
+	}, "token", time.Time{})
+	logs := &bytes.Buffer{}
+	master.Log = log.New(logs, "", 0)
+	client, _ := findResource("api-client")
+	states := []*desiredState{{resource: client, objects: map[string]map[string]interface{}{}}}
+	changes, err := master.plan(context.Background(), states, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].key != "old" {
+		t.Errorf("plan(prune) = %+v, want only the old client deleted", changes)
+	}
+	if !strings.Contains(logs.String(), "'cpcli' not pruned") {
+		t.Errorf("plan(prune) did not report the active client: %q", logs.String())
+	}
+}
+
+func TestConfirmPrune(t *testing.T) {
+	role, _ := findResource("role")
+	user, _ := findResource("local-user")
+	device, _ := findResource("network-device")
+	changes := []change{
+		{action: actionUpdate, resource: user, key: "kept"},
+		{action: actionDelete, resource: device, key: "10.0.0.1"},
+		{action: actionDelete, resource: user, key: "a"},
+		{action: actionDelete, resource: user, key: "b"},
+		{action: actionDelete, resource: role, key: "old"},
+	}
+	questions := fakeTerminal(t, true, "y\nn\n")
+	master := &Master{Log: log.New(&bytes.Buffer{}, "", 0)}
+	got, err := master.confirmPrune(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(got))
+	for _, c := range got {
+		keys = append(keys, c.key)
+	}
+	if strings.Join(keys, ",") != "kept,10.0.0.1,old" {
+		t.Errorf("confirmPrune() kept %q, want the local-user deletions dropped", keys)
+	}
+	want := "Prune 1 network-device objects not in the manifests? [y/N] \nPrune 2 local-user objects not in the manifests? [y/N] "
+	if asked := strings.Join(*questions, "\n"); asked != want {
+		t.Errorf("asked %q, want %q", asked, want)
+	}
+}
+
+func TestExecuteJournalsCreate(t *testing.T) {
+	testHome(t)
+	master := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":7,"name":"new"}`)
+	}, "token", time.Time{})
+	if err := master.execute(context.Background(), model.POST, "role", map[string]interface{}{"name": "new"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readJournal(master.journalFile())
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("journal entries %+v, %v, want the creation", entries, err)
+	}
+	if method, path, _, _, err := entries[0].inverse(); err != nil || method != model.DELETE || path != "role/7" {
+		t.Errorf("inverse got %s %s, %v, want DELETE role/7", method, path, err)
+	}
+}
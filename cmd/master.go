@@ -9,8 +9,11 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"sync"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
 	"github.com/rafahpe/cpcli/model"
 	"github.com/rafahpe/cpcli/term"
 	"github.com/spf13/viper"
@@ -20,14 +23,59 @@ import (
 type Master struct {
 	cppm model.Clearpass
 
+	// Credential backend, and the error opening it, if any
+	secrets    secretStore
+	secretsErr error
+	// Credentials read from --secret-file or --secret-stdin
+	creds credentials
+	// Settings updated in this run, to be saved to the config file
+	changes map[string]interface{}
+
 	// Logger for error messages
 	Log *log.Logger
 
 	// Options to mamage with Cobra
-	ConfigFile string
-	Options    term.Options
-	Force      bool
-	Query      []string
+	ConfigFile  string
+	Profile     string
+	Options     term.Options
+	Force       bool
+	Query       []string
+	Limit       int
+	Offset      int
+	Sort        string
+	Max         int
+	Count       bool
+	SecretFile  string
+	SecretStdin bool
+	// Request bodies, inline or @file, and their format
+	Data        string
+	InputFormat string
+	// Remove the attributes used by path and query templates from the body
+	StripFields bool
+	// Print the requests instead of sending them
+	DryRun bool
+	// Do not ask for confirmation of destructive requests
+	Yes bool
+	// Serializes writes to the journal
+	journalMutex sync.Mutex
+	// Warns once of changes that can't be journaled
+	journalWarning sync.Once
+	// Default journal of the run, and the notice of its path
+	journalRun    string
+	journalNotice sync.Once
+	// Bulk requests read from stdin
+	Parallel  int
+	Rate      float64
+	Unordered bool
+	FailFast  bool
+	Rejects   string // File to save the bodies of failed requests
+	// Format of error messages, ErrorsText or ErrorsJSON
+	Errors string
+	// Do not mask secrets in error messages
+	ShowSecrets bool
+	// Tracing of HTTP requests
+	Verbose   int
+	PrintCurl bool
 }
 
 // Error type for predefined errors
@@ -48,8 +96,20 @@ const (
 	ErrInvalidCreds = Error("Credentials are invalid or expired")
 	// ErrMissingResource returned when no resource is provided for export
 	ErrMissingResource = Error("No resource specified for import/export")
+	// ErrNoCount returned when the server does not return the count of items
+	ErrNoCount = Error("The server did not return the count of items")
 )
 
+// LogoutError returned when local credentials were removed,
+// but the tokens could not be revoked in the server.
+type LogoutError struct {
+	Err error
+}
+
+func (e LogoutError) Error() string {
+	return fmt.Sprint("Local credentials removed, but tokens could not be revoked in the server: ", e.Err)
+}
+
 // Singleton is the config holder for all commands
 var Singleton Master
 
@@ -79,6 +139,10 @@ func (master *Master) OnInit() {
 
 	// Find home directory.
 	master.Log = log.New(os.Stderr, "", 0)
+	if master.Errors != ErrorsText && master.Errors != ErrorsJSON {
+		master.Log.Fatal(ErrUnknownErrors)
+	}
+	model.ShowSecrets = master.ShowSecrets
 	home, err := homedir.Dir()
 	if err != nil {
 		master.Log.Fatal("Could not find home directory: ", err)
@@ -93,7 +157,8 @@ func (master *Master) OnInit() {
 		viper.AddConfigPath(home)
 		viper.SetConfigName(".cpcli")
 	}
-	viper.SetEnvPrefix("cppm")
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(envReplacer)
 	viper.AutomaticEnv() // read in environment variables that match
 
 	// Read or create the config file
@@ -104,8 +169,21 @@ func (master *Master) OnInit() {
 			master.Log.Fatal("initConfig Error: ", err)
 		}
 	}
+	// Select the profile from command line, CPPM_PROFILE or config file
+	master.Profile = viper.GetString("profile")
 
-	pageSize := viper.GetInt("pagesize")
+	// Open the credential backend
+	// Headless hosts have no keyring: the error is returned when
+	// credentials are saved, so commands that don't need them still work.
+	if master.secrets, master.secretsErr = master.openSecrets(); master.secretsErr != nil {
+		if backend := master.getString("secrets"); backend != "" && backend != SecretsKeyring {
+			master.Log.Print(master.secretsErr)
+		}
+	} else if err := master.migrateSecrets(); err != nil {
+		master.Log.Print("Error moving credentials from the config file to the credential backend: ", err)
+	}
+
+	pageSize := master.getInt("pagesize")
 	if pageSize <= 0 {
 		master.Options.PageSize = DefaultPageSize
 		master.Options.Paginate = false
@@ -115,18 +193,48 @@ func (master *Master) OnInit() {
 	}
 
 	// Init the connection to clearpass
-	server := viper.GetString("server")
-	token := viper.GetString("token")
-	refresh := viper.GetString("refresh")
-	unsafe := viper.GetBool("unsafe")
-	cookie := viper.GetString("cookie")
+	master.cppm = master.connect()
+	// Save the tokens if they are refreshed during a request
+	master.cppm.OnRefresh(func(token, refresh string) {
+		if err := master.Save(token, refresh); err != nil {
+			master.Log.Print("Error saving refreshed token: ", err)
+		}
+	})
+}
+
+// connect returns a client for the server of the active profile
+func (master *Master) connect() model.Clearpass {
+	server := master.getString("server")
+	client := master.getString("client")
+	token := master.getSecret("token")
+	refresh := master.getSecret("refresh")
+	unsafe := master.getBool("unsafe")
+	cookie := master.getSecret("cookie")
+	expires, err := time.Parse(time.RFC3339, master.getString("expires"))
+	if err != nil {
+		expires = time.Time{}
+	}
 	// Try to resd cookie from config
-	master.cppm = model.New(server, token, refresh, unmarshalCookie(cookie), unsafe)
+	cppm := model.New(server, client, token, refresh, expires, unmarshalCookie(cookie), unsafe)
+	cppm.SetRetry(model.RetryPolicy{
+		MaxAttempts: master.getInt("retries"),
+		BaseDelay:   master.getDuration("retry-delay"),
+		MaxDelay:    master.getDuration("retry-max-delay"),
+		RetryPOST:   master.getBool("retry-post"),
+	})
+	cppm.SetTrace(model.Trace{Level: master.Verbose, Curl: master.PrintCurl, Log: master.Log})
+	// Tokens cached by a previous run are refreshed with the client secret
+	// from the non-interactive sources, if the client is confidential
+	cppm.SecretSource(func() (string, error) {
+		secret, _, err := master.storedSecret("secret", server, client)
+		return secret, err
+	})
+	return cppm
 }
 
 // Make sure the file exists, otherwise Viper complains when saving
 func primeConfigFile(cfgFile string) {
-	fd, err := os.OpenFile(cfgFile, os.O_WRONLY|os.O_CREATE, 0644)
+	fd, err := os.OpenFile(cfgFile, os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		fmt.Println("primeConfigFile Error: ", err)
 		os.Exit(1)
@@ -136,33 +244,46 @@ func primeConfigFile(cfgFile string) {
 
 // Save login parameters
 func (master *Master) Save(token, refresh string) error {
+	master.saveProfile()
 	if token != "" {
-		viper.Set("token", token)
+		if err := master.setSecret("token", token); err != nil {
+			return err
+		}
 	}
 	if refresh != "" {
-		viper.Set("refresh", refresh)
+		if err := master.setSecret("refresh", refresh); err != nil {
+			return err
+		}
 	}
-	return viper.WriteConfig()
+	if expires := master.cppm.Expires(); !expires.IsZero() {
+		master.set("expires", expires.Format(time.RFC3339))
+	} else {
+		master.set("expires", "")
+	}
+	return master.writeConfig()
 }
 
 // SaveCookie saves weblogin cookie
 func (master *Master) SaveCookie(cookie []*http.Cookie) error {
-	viper.Set("cookie", marshalCookie(cookie))
-	return viper.WriteConfig()
+	master.saveProfile()
+	if err := master.setSecret("cookie", marshalCookie(cookie)); err != nil {
+		return err
+	}
+	return master.writeConfig()
 }
 
 // Login into the ClearPass. Return access and refresh token
 func (master *Master) Login() (string, string, error) {
-	server := viper.GetString("server")
+	server := master.getString("server")
 	if server == "" {
 		return "", "", ErrMissingserver
 	}
-	client := viper.GetString("client")
+	client := master.getString("client")
 	if client == "" {
 		return "", "", ErrMissingCreds
 	}
-	token := viper.GetString("token")
-	refresh := viper.GetString("refresh")
+	token := master.getSecret("token")
+	refresh := master.getSecret("refresh")
 	ctx := context.Background()
 	if token != "" && !master.Force {
 		token, refresh, err := master.cppm.Validate(ctx, server, client, "", token, refresh)
@@ -174,13 +295,13 @@ func (master *Master) Login() (string, string, error) {
 		}
 		fmt.Println("Authentication with cached credentials failed: ", err)
 	}
-	secret, err := term.Readline(fmt.Sprintf("Secret for '%s' (leave blank if public client): ", client), true)
+	secret, err := master.askSecret("secret", server, client, fmt.Sprintf("Secret for '%s' (leave blank if public client): ", client))
 	if err != nil {
 		return "", "", err
 	}
-	user, password := viper.GetString("user"), ""
+	user, password := master.getString("user"), ""
 	if user != "" {
-		password, err = term.Readline(fmt.Sprintf("Password for '%s' (leave blank if auth type is 'client_credentials'): ", user), true)
+		password, err = master.askSecret("password", server, user, fmt.Sprintf("Password for '%s' (leave blank if auth type is 'client_credentials'): ", user))
 		if err != nil {
 			return "", "", err
 		}
@@ -188,13 +309,133 @@ func (master *Master) Login() (string, string, error) {
 	return master.cppm.Login(ctx, server, client, secret, user, password)
 }
 
+// Logout from the ClearPass. Revokes the tokens in the server, and removes
+// them from the active profile. If revocation fails but the local
+// credentials are removed, returns a LogoutError.
+func (master *Master) Logout() error {
+	server := master.getString("server")
+	if server == "" {
+		return ErrMissingserver
+	}
+	revokeErr := master.cppm.Logout(context.Background(), server)
+	for _, name := range []string{"token", "refresh"} {
+		if err := master.setSecret(name, ""); err != nil {
+			return err
+		}
+	}
+	master.set("expires", "")
+	if err := master.writeConfig(); err != nil {
+		return err
+	}
+	if revokeErr != nil {
+		return LogoutError{Err: revokeErr}
+	}
+	return nil
+}
+
+// Status of the current session
+type Status struct {
+	Server     string     `json:"server"`
+	Profile    string     `json:"profile,omitempty"`
+	Client     string     `json:"client_id"`
+	User       string     `json:"user,omitempty"`
+	APISession bool       `json:"api_session"`
+	Expires    *time.Time `json:"expires,omitempty"`
+	Privileges []string   `json:"privileges,omitempty"`
+	WebSession bool       `json:"web_session"`
+	Version    string     `json:"server_version,omitempty"`
+}
+
+// Version of the CPPM software, as returned by cppm-version
+type cppmVersion struct {
+	Major   int `json:"app_major_version"`
+	Minor   int `json:"app_minor_version"`
+	Release int `json:"app_service_release"`
+	Build   int `json:"app_build_number"`
+}
+
+// getObject GETs a single object from the API
+func (master *Master) getObject(ctx context.Context, path string, obj interface{}) error {
+	reply := master.cppm.Request(model.GET, path, nil, nil)
+	if !reply.Next(ctx) {
+		if err := reply.Error(); err != nil {
+			return err
+		}
+		return fmt.Errorf("Empty reply from %s", path)
+	}
+	return json.Unmarshal(reply.Get(), obj)
+}
+
+// version returns the version of the CPPM software, e.g. "6.9.0.130064"
+func (master *Master) version(ctx context.Context) (string, error) {
+	var version cppmVersion
+	if err := master.getObject(ctx, "cppm-version", &version); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d.%d.%d.%d", version.Major, version.Minor, version.Release, version.Build), nil
+}
+
+// Status checks the API and web sessions, and the privileges of the user.
+func (master *Master) Status() (Status, error) {
+	status := Status{
+		Server:  master.getString("server"),
+		Profile: master.Profile,
+		Client:  master.getString("client"),
+		User:    master.getString("user"),
+	}
+	// Expiration time of the cached token, if known
+	if expires := master.cppm.Expires(); !expires.IsZero() {
+		status.Expires = &expires
+	}
+	if status.Server == "" {
+		return status, ErrMissingserver
+	}
+	ctx := context.Background()
+	// The session is valid unless oauth/me is rejected as unauthorized.
+	// Other endpoints may need privileges the token does not have.
+	if master.cppm.Token() != "" {
+		var me struct {
+			Name string `json:"name"`
+		}
+		err := master.getObject(ctx, "oauth/me", &me)
+		if restErr, ok := errors.Cause(err).(model.RestError); !ok || restErr.StatusCode != 401 {
+			if err != nil {
+				return status, err
+			}
+			status.APISession = true
+			if me.Name != "" {
+				status.User = me.Name
+			}
+		}
+	}
+	if status.APISession {
+		var privs struct {
+			Privileges []string `json:"privileges"`
+		}
+		if err := master.getObject(ctx, "oauth/privileges", &privs); err != nil {
+			return status, err
+		}
+		status.Privileges = privs.Privileges
+		version, err := master.version(ctx)
+		if err != nil {
+			return status, err
+		}
+		status.Version = version
+	}
+	if master.cppm.Cookies() != nil {
+		_, err := master.cppm.WebValidate(ctx, status.Server)
+		status.WebSession = (err == nil)
+	}
+	return status, nil
+}
+
 // WebLogin into the ClearPass. Return access and refresh token
 func (master *Master) WebLogin() ([]*http.Cookie, error) {
-	server := viper.GetString("server")
+	server := master.getString("server")
 	if server == "" {
 		return nil, ErrMissingserver
 	}
-	client := viper.GetString("user")
+	client := master.getString("user")
 	if client == "" {
 		return nil, ErrMissingCreds
 	}
@@ -210,7 +451,7 @@ func (master *Master) WebLogin() ([]*http.Cookie, error) {
 		}
 		fmt.Println("Authentication with cached credentials failed: ", err)
 	}
-	password, err := term.Readline(fmt.Sprintf("Password for '%s': ", client), true)
+	password, err := master.askSecret("password", server, client, fmt.Sprintf("Password for '%s': ", client))
 	if err != nil {
 		return nil, err
 	}
@@ -219,7 +460,7 @@ func (master *Master) WebLogin() ([]*http.Cookie, error) {
 
 // WebLogout from the ClearPass.
 func (master *Master) WebLogout() error {
-	server := viper.GetString("server")
+	server := master.getString("server")
 	if server == "" {
 		return ErrMissingserver
 	}
@@ -264,6 +505,49 @@ func (master *Master) Import(args []string) error {
 	return master.cppm.Import(context.Background(), fileName, resource, pass)
 }
 
+// matches returns the number of items matching the query in the path.
+// If the path is a single object, found is true but the count is unknown.
+func (master *Master) matches(path string, query model.Params) (count int, found bool, err error) {
+	params := make(model.Params, len(query)+2)
+	for key, val := range query {
+		params[key] = val
+	}
+	params["calculate_count"] = "true"
+	params["limit"] = "1"
+	feed := master.cppm.Request(model.GET, path, params, nil)
+	found = feed.Next(context.Background())
+	if err := feed.Error(); err != nil {
+		return 0, false, err
+	}
+	count, ok := feed.Count()
+	if !ok {
+		return -1, found, nil
+	}
+	return count, count > 0, nil
+}
+
+// count prints the total number of items in the path
+func (master *Master) count(path string, query model.Params) error {
+	count, _, err := master.matches(path, query)
+	if err != nil {
+		return err
+	}
+	if count < 0 {
+		return ErrNoCount
+	}
+	fmt.Println(count)
+	return nil
+}
+
+// input returns the request bodies from --data, or stdin if it is not a tty.
+// Returns nil if there are no bodies.
+func (master *Master) input() (term.Input, error) {
+	if master.Data != "" {
+		return term.Data(master.Data, master.InputFormat)
+	}
+	return term.Stdin(master.InputFormat)
+}
+
 // Run runs a command against the Clearpass
 func (master *Master) Run(method model.Method, args []string) error {
 	if len(args) < 1 {
@@ -274,29 +558,69 @@ func (master *Master) Run(method model.Method, args []string) error {
 	if err != nil {
 		return err
 	}
-	// Check if we are in a pipe
-	reader, err := term.Stdin()
+	if master.Count {
+		return master.count(args[0], query)
+	}
+	// Check if we are given bodies, or in a pipe
+	reader, err := master.input()
 	if err != nil {
 		return err
 	}
 	path, format := args[0], args[1:]
-	// If stdin is a tty, run just once
-	if reader == nil {
-		reader = term.Once(nil)
-	}
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	for reader.Next() {
-		// Beware of typed nil! reader.Get() may be nil, but it's of type json.RawMessage
-		// body, on the other hand, will be untyped nil.
-		var body interface{}
-		if item := reader.Get(); item != nil {
-			body = item
+	if err := model.CheckSelectors(format); err != nil {
+		return err
+	}
+	// Prefetching needs the total count of items
+	prefetch, workers := master.getInt("prefetch"), master.getInt("workers")
+	if _, ok := query["calculate_count"]; method == model.GET && prefetch > 0 && !ok {
+		query["calculate_count"] = "true"
+	}
+	// The path and query may be templates, filled from each body
+	tmpl, err := newRequestTemplate(path, query)
+	if err != nil {
+		return err
+	}
+	if err := master.confirm(method, path, query, tmpl); err != nil {
+		return err
+	}
+	// send runs a single request and writes the reply
+	send := func(ctx context.Context, w io.Writer, options term.Options, path string, query model.Params, body interface{}) error {
+		if master.DryRun {
+			return master.dryRun(w, method, path, query, body)
 		}
-		feed := master.cppm.Request(method, path, query, body)
-		if err := term.Output(ctx, master.Options, feed, format); err != nil {
-			return err
+		if master.journaled(method, query) {
+			after, err := master.journalRequest(ctx, method, path, query, body)
+			if err != nil || after == nil {
+				return err
+			}
+			return term.OutputTo(ctx, w, options, model.NewReply(after, nil), format)
 		}
+		feed := master.cppm.Request(method, path, query, body).SetMax(master.Max).SetPrefetch(prefetch, workers)
+		return term.OutputTo(ctx, w, options, feed, format)
 	}
-	return reader.Error()
+	ctx := context.Background()
+	// If there are no bodies, run just once
+	if reader == nil {
+		if tmpl != nil {
+			return ErrTemplateBody
+		}
+		return send(ctx, os.Stdout, master.Options, path, query, nil)
+	}
+	// Otherwise, run once per item. Stdin is busy, can't prompt for pages.
+	options := master.Options
+	options.Paginate = false
+	return master.bulk(ctx, reader, func(ctx context.Context, w io.Writer, body interface{}) error {
+		path, query := path, query
+		if tmpl != nil {
+			var err error
+			if path, query, body, err = tmpl.render(path, query, body, master.StripFields); err != nil {
+				return err
+			}
+			// GET and DELETE bodies are only used to fill the templates
+			if method == model.GET || method == model.DELETE {
+				body = nil
+			}
+		}
+		return send(ctx, w, options, path, query, body)
+	})
 }
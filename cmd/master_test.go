@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/rafahpe/cpcli/model"
+	"github.com/spf13/viper"
+)
+
+// testMaster returns a Master connected to a test server, with the
+// credentials in an in-memory keyring and the settings in a temporary
+// config file.
+func testMaster(t *testing.T, handler http.HandlerFunc, token string, expires time.Time) *Master {
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+	address := strings.TrimPrefix(server.URL, "https://")
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	name := filepath.Join(t.TempDir(), "cpcli.yaml")
+	config := "server: " + address + "\nclient: cpcli\n"
+	if err := ioutil.WriteFile(name, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	viper.SetConfigFile(name)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	store := keyringStore{ring: keyring.NewArrayKeyring(nil), server: address, client: "cpcli"}
+	if token != "" {
+		store.Set("token", token)
+		store.Set("refresh", "refresh")
+	}
+	master := &Master{
+		cppm:    model.New(address, "cpcli", token, "", expires, nil, true),
+		secrets: store,
+		Log:     log.New(&bytes.Buffer{}, "", 0),
+	}
+	master.cppm.SetRetry(model.RetryPolicy{MaxAttempts: 1})
+	return master
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error // Revocation error, wrapped in a LogoutError
+	}{
+		{200, nil},
+		{404, model.ErrRevokeUnsupported},
+		{405, model.ErrRevokeUnsupported},
+		{500, Error("server error")},
+	}
+	for _, test := range tests {
+		master := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(test.status)
+			w.Write([]byte(`{}`))
+		}, "token", time.Now().Add(time.Hour))
+		err := master.Logout()
+		logoutErr, ok := err.(LogoutError)
+		switch {
+		case test.want == nil && err != nil:
+			t.Errorf("status %d: got error %s", test.status, err)
+		case test.want != nil && !ok:
+			t.Errorf("status %d: got error %v, want a LogoutError", test.status, err)
+		case test.want == model.ErrRevokeUnsupported && logoutErr.Err != test.want:
+			t.Errorf("status %d: got error %v, want %s", test.status, logoutErr.Err, test.want)
+		}
+		for _, name := range []string{"token", "refresh"} {
+			if value, _ := master.secrets.Get(name); value != "" {
+				t.Errorf("status %d: %s not removed from the credential backend", test.status, name)
+			}
+		}
+		if master.cppm.Token() != "" {
+			t.Errorf("status %d: token not removed from the client", test.status)
+		}
+		file := viper.New()
+		file.SetConfigFile(viper.ConfigFileUsed())
+		if err := file.ReadInConfig(); err != nil {
+			t.Fatal(err)
+		}
+		if file.GetString("expires") != "" {
+			t.Errorf("status %d: expiration kept in the config file", test.status)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name    string
+		token   string
+		expires time.Time
+		valid   bool // Token accepted by the server
+		session bool
+	}{
+		{"valid", "token", expires, true, true},
+		{"expired", "token", expires.Add(-2 * time.Hour), false, false},
+		{"no token", "", time.Time{}, true, false},
+		{"no expiration", "token", time.Time{}, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int32
+			master := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				w.Header().Set("Content-Type", "application/json")
+				if !test.valid {
+					w.WriteHeader(401)
+					w.Write([]byte(`{"detail":"expired"}`))
+					return
+				}
+				switch r.URL.Path {
+				case "/api/oauth/me":
+					w.Write([]byte(`{"name":"admin"}`))
+				case "/api/oauth/privileges":
+					w.Write([]byte(`{"privileges":["#api_docs"]}`))
+				case "/api/cppm-version":
+					w.Write([]byte(`{"app_major_version":6,"app_minor_version":9,"app_service_release":0,"app_build_number":130064}`))
+				default:
+					// The token may not be allowed to read api-client
+					w.WriteHeader(403)
+				}
+			}, test.token, test.expires)
+			status, err := master.Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.APISession != test.session {
+				t.Errorf("API session = %v, want %v", status.APISession, test.session)
+			}
+			if test.expires.IsZero() != (status.Expires == nil) {
+				t.Errorf("expiration = %v, want %s", status.Expires, test.expires)
+			} else if status.Expires != nil && !status.Expires.Equal(test.expires) {
+				t.Errorf("expiration = %s, want %s", status.Expires, test.expires)
+			}
+			if test.token == "" && atomic.LoadInt32(&requests) != 0 {
+				t.Errorf("%d requests sent without a token", requests)
+			}
+			if test.session && (status.User != "admin" || status.Version != "6.9.0.130064" || len(status.Privileges) != 1) {
+				t.Errorf("status of valid session = %+v", status)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		query model.Params
+		reply string
+		count int
+		found bool
+	}{
+		{"empty filter", "endpoint", nil, `{"_embedded":{"items":[{"id":1}]},"count":25}`, 25, true},
+		{"matching filter", "endpoint", model.Params{"filter": `{"status":"Known"}`}, `{"_embedded":{"items":[{"id":1}]},"count":3}`, 3, true},
+		{"non-matching filter", "endpoint", model.Params{"filter": `{"status":"none"}`}, `{"_embedded":{"items":[]},"count":0}`, 0, false},
+		{"single object", "endpoint/1", nil, `{"id":1}`, -1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			master := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+				query := r.URL.Query()
+				if query.Get("calculate_count") != "true" || query.Get("limit") != "1" {
+					t.Errorf("query %v does not ask for the count only", query)
+				}
+				for key, val := range test.query {
+					if query.Get(key) != val {
+						t.Errorf("query %s = %q, want %q", key, query.Get(key), val)
+					}
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(test.reply))
+			}, "token", time.Time{})
+			count, found, err := master.matches(test.path, test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != test.count || found != test.found {
+				t.Errorf("got %d, %v, want %d, %v", count, found, test.count, test.found)
+			}
+		})
+	}
+}
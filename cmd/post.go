@@ -0,0 +1,54 @@
+// Copyright © 2017 Rafael Rivero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/rafahpe/cpcli/model"
+	"github.com/spf13/cobra"
+)
+
+// postCmd represents the post command
+var postCmd = &cobra.Command{
+	Use:   "post",
+	Short: "Make a POST request",
+	Long: `Make a POST request, to create new objects.
+
+  - The first parameter is the path of the collection, e.g. "endpoint".
+  - The body can be given with -d, inline or as @file. Otherwise, if stdin
+    is a pipe, bodies are read from it. JSON objects (compact or pretty),
+    arrays (one request per item), YAML (--input-format yaml), HJSON
+    (--input-format hjson) and CSV (--input-format csv) are accepted.
+    Each body is posted in its own request. Use --parallel and --rate to
+    run several at once, --fail-fast to stop at the first failure.
+  - CSV input needs a header row with the attribute of each column, which
+    can be nested ("attributes.Owner") and typed ("enabled:bool"). Types
+    are string (default), int, float, bool and json. Empty cells are skipped.
+  - The path and query values can be templates filled from the attributes
+    of each body, e.g. 'endpoint/mac-address/{{.mac_address}}'. Values are
+    escaped for the path, and JSON-escaped inside filters, e.g.
+    -q 'filter={"name":"{{.name}}"}'. Use --strip-template-fields to remove
+    those attributes from the body.
+  - If more parameters are provided, they are considered attributes to dump
+    from the reply, for instance "id", "mac_address"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := Singleton.Run(model.POST, args); err != nil {
+			Singleton.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(postCmd)
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rafahpe/cpcli/model"
+	"github.com/spf13/viper"
+)
+
+// Settings that are stored per profile, under the "profiles" section of
+// the config file:
+//
+//	profiles:
+//	  lab:
+//	    server: cppm-lab.example.com
+//	    client: cpcli
+//	  production:
+//	    server: cppm.example.com
+//	    client: cpcli
+const profilesKey = "profiles"
+
+// Settings can be given in environment variables, e.g. CPPM_SERVER or
+// CPPM_CREDENTIAL_HELPER for "credential-helper"
+const envPrefix = "cppm"
+
+// envReplacer turns setting names into valid variable names
+var envReplacer = strings.NewReplacer("-", "_", ".", "_")
+
+// envName returns the environment variable for the setting
+func envName(name string) string {
+	return strings.ToUpper(envPrefix + "_" + envReplacer.Replace(name))
+}
+
+// flagChanged checks if the setting was given in the command line
+func flagChanged(name string) bool {
+	flag := RootCmd.PersistentFlags().Lookup(name)
+	return flag != nil && flag.Changed
+}
+
+// overridden checks if the setting was given in the command line or
+// environment, so it takes precedence over the profile.
+func overridden(name string) bool {
+	if flagChanged(name) {
+		return true
+	}
+	_, ok := os.LookupEnv(envName(name))
+	return ok
+}
+
+// Settings of the session, never inherited from the top level of the
+// config file, since they are only valid for the server of the profile.
+var sessionSettings = map[string]bool{
+	"token":   true,
+	"refresh": true,
+	"expires": true,
+	"cookie":  true,
+}
+
+// key returns the viper key for the setting, taking into account
+// the active profile. Settings missing in the profile are taken from
+// the top level of the config file, or the flag defaults.
+func (master *Master) key(name string) string {
+	if master.Profile == "" || overridden(name) {
+		return name
+	}
+	key := strings.Join([]string{profilesKey, master.Profile, name}, ".")
+	if viper.IsSet(key) || sessionSettings[name] {
+		return key
+	}
+	return name
+}
+
+// getString returns a setting from the active profile
+func (master *Master) getString(name string) string {
+	return viper.GetString(master.key(name))
+}
+
+// getInt returns a setting from the active profile
+func (master *Master) getInt(name string) int {
+	return viper.GetInt(master.key(name))
+}
+
+// getDuration returns a setting from the active profile
+func (master *Master) getDuration(name string) time.Duration {
+	return viper.GetDuration(master.key(name))
+}
+
+// getBool returns a setting from the active profile
+func (master *Master) getBool(name string) bool {
+	return viper.GetBool(master.key(name))
+}
+
+// set updates a setting in the active profile. Settings are always
+// stored in the profile, even if they were overridden by flags.
+// Only the settings updated here are saved by writeConfig.
+func (master *Master) set(name string, value interface{}) {
+	key := name
+	if master.Profile != "" {
+		key = strings.Join([]string{profilesKey, master.Profile, name}, ".")
+	}
+	viper.Set(key, value)
+	if master.changes == nil {
+		master.changes = make(map[string]interface{})
+	}
+	master.changes[key] = value
+}
+
+// saveProfile stores the connection settings given in the command line
+// into the active profile, so they don't have to be repeated next time.
+// Settings from the environment only apply to the current run.
+func (master *Master) saveProfile() {
+	if master.Profile == "" {
+		return
+	}
+	for _, name := range []string{"server", "client", "user", "unsafe", "pagesize", "secrets", "credential-helper"} {
+		if flagChanged(name) {
+			master.set(name, viper.Get(name))
+		}
+	}
+}
+
+// profileClient returns a client for the server of another profile, with
+// its own credentials. Tokens refreshed by this client are not saved.
+func (master *Master) profileClient(profile string) (model.Clearpass, error) {
+	if !viper.IsSet(strings.Join([]string{profilesKey, profile}, ".")) {
+		return nil, fmt.Errorf("Unknown profile '%s'", profile)
+	}
+	active, secrets, secretsErr := master.Profile, master.secrets, master.secretsErr
+	defer func() {
+		master.Profile, master.secrets, master.secretsErr = active, secrets, secretsErr
+	}()
+	master.Profile = profile
+	if master.secrets, master.secretsErr = master.openSecrets(); master.secretsErr != nil {
+		return nil, master.secretsErr
+	}
+	if master.getString("server") == "" {
+		return nil, ErrMissingserver
+	}
+	return master.connect(), nil
+}
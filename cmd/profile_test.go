@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// setFlag sets a root flag for the test, as if given in the command line
+func setFlag(t *testing.T, name, value string) {
+	flag := RootCmd.PersistentFlags().Lookup(name)
+	previous := flag.Value.String()
+	if err := flag.Value.Set(value); err != nil {
+		t.Fatal(err)
+	}
+	flag.Changed = true
+	t.Cleanup(func() {
+		flag.Value.Set(previous)
+		flag.Changed = false
+	})
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		setting string
+		flag    string
+		env     string
+		want    string
+	}{
+		{"no profile", "", "server", "", "", "server"},
+		{"profile value", "lab", "server", "", "", "profiles.lab.server"},
+		{"missing in profile", "lab", "client", "", "", "client"},
+		{"session setting", "lab", "token", "", "", "profiles.lab.token"},
+		{"flag override", "lab", "server", "cppm.example.com", "", "server"},
+		{"env override", "lab", "credential-helper", "", "CPPM_CREDENTIAL_HELPER", "credential-helper"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			viper.Set("server", "top.example.com")
+			viper.Set("profiles.lab.server", "lab.example.com")
+			viper.Set("profiles.lab.credential-helper", "helper")
+			if test.flag != "" {
+				setFlag(t, test.setting, test.flag)
+			}
+			if test.env != "" {
+				t.Setenv(test.env, "value")
+			}
+			master := &Master{Profile: test.profile}
+			if got := master.key(test.setting); got != test.want {
+				t.Errorf("key(%q) = %q, want %q", test.setting, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"server":            "CPPM_SERVER",
+		"credential-helper": "CPPM_CREDENTIAL_HELPER",
+		"retry-max-delay":   "CPPM_RETRY_MAX_DELAY",
+	}
+	for name, want := range tests {
+		if got := envName(name); got != want {
+			t.Errorf("envName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestEnvReplacer(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	t.Setenv("CPPM_CREDENTIAL_HELPER", "pass cppm")
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(envReplacer)
+	viper.AutomaticEnv()
+	if got := viper.GetString("credential-helper"); got != "pass cppm" {
+		t.Errorf("credential-helper = %q, want the environment value", got)
+	}
+}
+
+func TestSaveProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		flag    bool
+		env     bool
+		saved   bool
+	}{
+		{"no profile", "", true, false, false},
+		{"flag", "lab", true, false, true},
+		{"environment", "lab", false, true, false},
+		{"not given", "lab", false, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			viper.Set("server", "new.example.com")
+			if test.flag {
+				setFlag(t, "server", "new.example.com")
+			}
+			if test.env {
+				t.Setenv("CPPM_SERVER", "new.example.com")
+			}
+			master := &Master{Profile: test.profile}
+			master.saveProfile()
+			_, saved := master.changes["profiles.lab.server"]
+			if saved != test.saved || (!test.saved && len(master.changes) > 0) {
+				t.Errorf("saved = %v, changes %v, want saved = %v", saved, master.changes, test.saved)
+			}
+		})
+	}
+}
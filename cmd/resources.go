@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rafahpe/cpcli/model"
+)
+
+// resource is a type of object managed by cpcli as a whole collection
+type resource struct {
+	Type string // Name used in manifests, e.g. "role"
+	Path string // REST collection, e.g. "role"
+	Key  string // Natural key, unique among the objects of the collection
+	// A single object with settings, not a collection
+	Single bool
+	// Data, not configuration. Not included in snapshots by default.
+	Data bool
+	// Pruning may lock users or devices out, asks for each type
+	Guarded bool
+}
+
+// resources known by cpcli, in dependency order: objects may
+// refer to objects of the types before them, but not after.
+var resources = []resource{
+	{Type: "static-host-list", Path: "static-host-list", Key: "name"},
+	{Type: "role", Path: "role", Key: "name"},
+	{Type: "role-mapping", Path: "role-mapping", Key: "name"},
+	{Type: "enforcement-profile", Path: "enforcement-profile", Key: "name"},
+	{Type: "enforcement-policy", Path: "enforcement-policy", Key: "name"},
+	// Devices are matched by address, names are not unique
+	{Type: "network-device", Path: "network-device", Key: "ip_address", Guarded: true},
+	{Type: "network-device-group", Path: "network-device-group", Key: "name"},
+	{Type: "local-user", Path: "local-user", Key: "user_id", Guarded: true},
+	{Type: "endpoint", Path: "endpoint", Key: "mac_address", Data: true},
+	{Type: "api-client", Path: "api-client", Key: "client_id", Guarded: true},
+	{Type: "guest-settings", Path: "guestmanager", Single: true},
+}
+
+// findResource returns the known resource with the given type
+func findResource(name string) (resource, bool) {
+	for _, r := range resources {
+		if r.Type == name {
+			return r, true
+		}
+	}
+	return resource{}, false
+}
+
+// defaultKey returns the natural key of the collection in the path,
+// "name" if it is not a known resource, or "" for settings
+func defaultKey(path string) string {
+	path = strings.Trim(path, "/")
+	for _, r := range resources {
+		if r.Path == path {
+			return r.Key
+		}
+	}
+	return "name"
+}
+
+// settingsKey is the key of the object of settings resources
+const settingsKey = "settings"
+
+// resourceTypes lists the names of the known resources
+func resourceTypes() []string {
+	names := make([]string, 0, len(resources))
+	for _, r := range resources {
+		names = append(names, r.Type)
+	}
+	return names
+}
+
+// builtIn checks if the object is predefined in the server. ClearPass
+// names its predefined roles, profiles and policies in brackets, e.g.
+// "[Guest]", and they can't be deleted.
+func builtIn(object map[string]interface{}) bool {
+	name, ok := object["name"].(string)
+	return ok && strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]")
+}
+
+// naturalKey returns the value of the key attribute of the object, as a
+// string. MAC addresses are normalized, so they match in any format.
+// Settings have no key attribute, and are named settingsKey.
+func naturalKey(object map[string]interface{}, key string) (string, bool) {
+	if key == "" {
+		return settingsKey, true
+	}
+	value, ok := object[key]
+	if !ok || value == nil {
+		return "", false
+	}
+	text := fmt.Sprint(value)
+	if key == "mac_address" {
+		text = string(model.NewMAC(text))
+	}
+	return text, text != ""
+}
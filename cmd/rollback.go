@@ -0,0 +1,82 @@
+// Copyright © 2017 Rafael Rivero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// Roll back objects changed since the journal was written
+var rollbackForce bool
+
+// Roll back the newest journal of the profile
+var rollbackLast bool
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [journal]",
+	Short: "Undo the changes recorded in a journal",
+	Long: `Undo the changes recorded in a journal file, in reverse order.
+
+  - Changes to single objects made by POST, PUT, PATCH and DELETE
+    requests, and by apply, are recorded by default in a new file for
+    each run, in ~/.cpcli-journal/<profile>/. The path is printed after
+    the first change. Use --journal <file> (or the 'journal' config
+    variable) to append to a single file instead, or --journal off to
+    disable it.
+  - Use --last instead of a file to roll back the newest journal of the
+    profile.
+  - Deleted objects are created again, with a new id. Patched attributes
+    and replaced objects are set back to their previous values, and
+    objects created by POST or PUT are deleted. Attributes added by
+    PATCH or PUT are reported, they can't be removed.
+  - Secret attributes (passwords, shared secrets...) are not saved to the
+    journal, so they can't be restored. Replaced objects are patched back
+    keeping their current secrets, and deleted objects that had secrets
+    are reported, to be created again by hand.
+  - Objects changed since the journaled request are reported and skipped,
+    unless --force is given.
+  - Changes rolled back are marked in the journal, and skipped if it is
+    rolled back again. The requests of the rollback are journaled too,
+    so the rollback can be undone in turn, unless the journal is the one
+    rolled back (e.g. the single file given by --journal <file>).
+  - Asks for confirmation unless --yes is given. Use --dry-run to print
+    the requests without sending them.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if (len(args) == 0) == !rollbackLast {
+			Singleton.Fatal(ErrRollbackJournal)
+		}
+		var name string
+		if rollbackLast {
+			last, err := Singleton.lastJournal()
+			if err != nil {
+				Singleton.Fatal(err)
+			}
+			name = last
+		} else {
+			name = args[0]
+		}
+		if err := Singleton.Rollback(name, rollbackForce); err != nil {
+			Singleton.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.Flags().BoolVarP(&rollbackForce, "force", "F", false, "Roll back objects changed since the journal was written")
+	rollbackCmd.Flags().BoolVar(&rollbackLast, "last", false, "Roll back the newest journal of the profile")
+}
@@ -17,8 +17,12 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/rafahpe/cpcli/model"
+	"github.com/rafahpe/cpcli/term"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -32,7 +36,16 @@ var RootCmd = &cobra.Command{
 It performs:
 
   - Authentication against Clearpass with the "login" command.
-  - GET, POST, PUT, PATCH, DELETE requests to the API.`,
+  - GET, POST, PUT, PATCH, DELETE requests to the API.
+
+Exit codes:
+
+  1  Any other error
+  3  Not logged in, or credentials invalid or expired
+  4  Request rejected by the server (4xx)
+  5  Server error (5xx)
+  6  Network error, no reply from the server
+  7  Some of the requests read from stdin failed`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	//	Run: func(cmd *cobra.Command, args []string) { },
@@ -47,6 +60,14 @@ func Execute() {
 	}
 }
 
+// flagAliases maps the alternative names of flags to the flag itself
+func flagAliases(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	if name == "expr" {
+		name = "jq"
+	}
+	return pflag.NormalizedName(name)
+}
+
 // DefaultPageSize is the default page size for pagination
 const DefaultPageSize = 24
 
@@ -59,12 +80,42 @@ func init() {
 
 	// Flags not stored in the config file
 	RootCmd.PersistentFlags().StringVar(&Singleton.ConfigFile, "config", "", "config file (default is $HOME/.cpcli)")
-	RootCmd.PersistentFlags().BoolVarP(&(Singleton.Options.SkipHeaders), "skip-headers", "H", false, "Skip headers when dumping CSV")
+	RootCmd.PersistentFlags().BoolVarP(&(Singleton.Options.SkipHeaders), "skip-headers", "H", false, "Skip headers when dumping CSV, TSV and tables")
 	RootCmd.PersistentFlags().StringArrayVarP(&(Singleton.Query), "query", "q", nil, "Query params (e.g. -q sort=-id -q filter={mac:'00:86:df:11:22:33'}")
+	RootCmd.PersistentFlags().IntVar(&(Singleton.Limit), "limit", 0, "Number of items per page requested to the server (max 1000)")
+	RootCmd.PersistentFlags().IntVar(&(Singleton.Offset), "offset", 0, "Number of items to skip")
+	RootCmd.PersistentFlags().StringVar(&(Singleton.Sort), "sort", "", "Sort order, e.g. '-id' or '+name'")
+	RootCmd.PersistentFlags().IntVar(&(Singleton.Max), "max", 0, "Stop after this many items")
+	RootCmd.PersistentFlags().BoolVar(&(Singleton.Count), "count", false, "Print only the total count of items")
 	RootCmd.PersistentFlags().BoolVarP(&(Singleton.Options.PrettyPrint), "prettyprint", "p", false, "Pretty print json output")
+	RootCmd.PersistentFlags().StringVarP(&(Singleton.Options.Output), "output", "o", "", "Output format: "+strings.Join(term.Formats(), ", ")+" (default json, or csv if columns are selected)")
+	RootCmd.PersistentFlags().StringVar(&(Singleton.Options.Expr), "jq", "", "jq expression to transform each reply before output (e.g. --jq '{mac: .mac_address}'), also --expr")
+	RootCmd.PersistentFlags().StringVar(&(Singleton.Options.Delimiter), "delimiter", ",", "CSV field delimiter, a single character or '\\t'")
+	RootCmd.PersistentFlags().StringVar(&(Singleton.Options.Nested), "nested", term.NestedJSON, "How to render nested arrays in CSV cells: 'json' or 'join'")
+	RootCmd.PersistentFlags().BoolVar(&(Singleton.Options.LegacyCSV), "legacy-csv", false, "Dump columns as JSON values separated by ';', as older versions")
+	RootCmd.PersistentFlags().StringVarP(&(Singleton.Data), "data", "d", "", "Request body, or @file to read the bodies from a file (@- for stdin)")
+	RootCmd.PersistentFlags().StringVar(&(Singleton.InputFormat), "input-format", "", "Format of request bodies: json (default), yaml, hjson or csv (header row with attribute paths, e.g. 'attributes.Owner', and optional types, e.g. 'enabled:bool'). Arrays are split into one request per item")
+	RootCmd.PersistentFlags().BoolVar(&(Singleton.StripFields), "strip-template-fields", false, "Remove the attributes used in path and query templates (e.g. 'endpoint/{{.id}}') from the request body")
+	RootCmd.PersistentFlags().BoolVar(&(Singleton.DryRun), "dry-run", false, "Print the method, URL, query and body of each request instead of sending it")
+	RootCmd.PersistentFlags().BoolVarP(&(Singleton.Yes), "yes", "y", false, "Do not ask for confirmation before DELETE, or PATCH and PUT with a filter")
+	RootCmd.PersistentFlags().IntVar(&(Singleton.Parallel), "parallel", 1, "Number of concurrent requests for bodies read from stdin")
+	RootCmd.PersistentFlags().Float64Var(&(Singleton.Rate), "rate", 0, "Max requests per second for bodies read from stdin (0 for no limit)")
+	RootCmd.PersistentFlags().BoolVar(&(Singleton.Unordered), "unordered", false, "Write the output of requests from stdin as they finish, instead of in input order")
+	RootCmd.PersistentFlags().BoolVar(&(Singleton.FailFast), "fail-fast", false, "Stop at the first failed request read from stdin")
+	RootCmd.PersistentFlags().StringVar(&(Singleton.Rejects), "rejects", "", "Save the bodies read from stdin that failed to this file, in the input format, for replay")
+	RootCmd.PersistentFlags().StringVar(&(Singleton.Errors), "errors", ErrorsText, "Format of error messages: 'text' or 'json'")
+	RootCmd.PersistentFlags().BoolVar(&(Singleton.ShowSecrets), "show-secrets", false, "Do not mask tokens, passwords and cookies in error messages and traces (for debugging only)")
+	RootCmd.PersistentFlags().CountVarP(&(Singleton.Verbose), "verbose", "v", "Trace HTTP requests: -v for request and status lines, -vv for headers and bodies")
+	RootCmd.PersistentFlags().BoolVar(&(Singleton.PrintCurl), "print-curl", false, "Print an equivalent curl command for each HTTP request")
 	RootCmd.PersistentFlags().BoolVarP(&(Singleton.Force), "force", "F", false, "When used with 'login', force new authentication")
+	RootCmd.PersistentFlags().StringVar(&(Singleton.SecretFile), "secret-file", "", "Read client secret and password from a file, as 'secret=...' and 'password=...' lines")
+	RootCmd.PersistentFlags().BoolVar(&(Singleton.SecretStdin), "secret-stdin", false, "Read client secret and password from stdin, as 'secret=...' and 'password=...' lines")
+
+	// --expr is the same flag as --jq
+	RootCmd.SetGlobalNormalizationFunc(flagAliases)
 
 	// Flags stored in config file / viper
+	RootCmd.PersistentFlags().StringP("profile", "C", "", "Connection profile from the config file (also CPPM_PROFILE)")
 	RootCmd.PersistentFlags().StringP("server", "s", "", "CPPM Server name or IP address")
 	RootCmd.PersistentFlags().StringP("client", "c", "", "Client ID for accesing the CPPM API")
 	RootCmd.PersistentFlags().StringP("user", "u", "", "User name for accesing the CPPM API")
@@ -72,7 +123,17 @@ func init() {
 	RootCmd.PersistentFlags().StringP("refresh", "r", "", "OAUTH refresh token")
 	RootCmd.PersistentFlags().BoolP("unsafe", "U", false, "Skip server certificate verification")
 	RootCmd.PersistentFlags().IntP("pagesize", "P", DefaultPageSize, "Pagesize of the requests")
+	RootCmd.PersistentFlags().Int("prefetch", 0, "Number of pages to fetch ahead in the background (0 disables prefetching)")
+	RootCmd.PersistentFlags().Int("workers", 4, "Number of concurrent requests when prefetching pages")
+	RootCmd.PersistentFlags().Int("retries", model.DefaultRetryPolicy.MaxAttempts, "Max attempts for requests failing with network errors, 429 or 5xx")
+	RootCmd.PersistentFlags().Duration("retry-delay", model.DefaultRetryPolicy.BaseDelay, "Delay before the first retry, doubled on each attempt")
+	RootCmd.PersistentFlags().Duration("retry-max-delay", model.DefaultRetryPolicy.MaxDelay, "Max delay between retries. Requests fail if the server asks to wait longer (Retry-After)")
+	RootCmd.PersistentFlags().Bool("retry-post", false, "Retry POST requests too (they are not idempotent)")
+	RootCmd.PersistentFlags().String("journal", "", "Save objects before and after each POST, PUT, PATCH and DELETE to this file, for 'rollback'. Default a new file for each run in ~/.cpcli-journal/<profile>/, 'off' to disable")
+	RootCmd.PersistentFlags().String("credential-helper", "", "Command to get client secret and password from, git-credential style")
+	RootCmd.PersistentFlags().String("secrets", SecretsKeyring, "Where to store tokens and cookies: 'keyring', 'file' (encrypted, passphrase in CPPM_PASSPHRASE) or 'plain' (config file)")
 
+	viper.BindPFlag("profile", RootCmd.PersistentFlags().Lookup("profile"))
 	viper.BindPFlag("server", RootCmd.PersistentFlags().Lookup("server"))
 	viper.BindPFlag("client", RootCmd.PersistentFlags().Lookup("client"))
 	viper.BindPFlag("user", RootCmd.PersistentFlags().Lookup("user"))
@@ -80,4 +141,13 @@ func init() {
 	viper.BindPFlag("refresh", RootCmd.PersistentFlags().Lookup("refresh"))
 	viper.BindPFlag("unsafe", RootCmd.PersistentFlags().Lookup("unsafe"))
 	viper.BindPFlag("pagesize", RootCmd.PersistentFlags().Lookup("pagesize"))
+	viper.BindPFlag("secrets", RootCmd.PersistentFlags().Lookup("secrets"))
+	viper.BindPFlag("prefetch", RootCmd.PersistentFlags().Lookup("prefetch"))
+	viper.BindPFlag("workers", RootCmd.PersistentFlags().Lookup("workers"))
+	viper.BindPFlag("retries", RootCmd.PersistentFlags().Lookup("retries"))
+	viper.BindPFlag("retry-delay", RootCmd.PersistentFlags().Lookup("retry-delay"))
+	viper.BindPFlag("retry-max-delay", RootCmd.PersistentFlags().Lookup("retry-max-delay"))
+	viper.BindPFlag("retry-post", RootCmd.PersistentFlags().Lookup("retry-post"))
+	viper.BindPFlag("journal", RootCmd.PersistentFlags().Lookup("journal"))
+	viper.BindPFlag("credential-helper", RootCmd.PersistentFlags().Lookup("credential-helper"))
 }
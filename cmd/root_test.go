@@ -0,0 +1,19 @@
+package cmd
+
+import "testing"
+
+func TestExprAlias(t *testing.T) {
+	defer func() {
+		Singleton.Options.Expr = ""
+		RootCmd.PersistentFlags().Lookup("jq").Changed = false
+	}()
+	if err := rollbackCmd.ParseFlags([]string{"--expr", ".name"}); err != nil {
+		t.Fatal(err)
+	}
+	if Singleton.Options.Expr != ".name" {
+		t.Errorf("--expr set %q, want .name", Singleton.Options.Expr)
+	}
+	if flag := rollbackCmd.Flags().Lookup("expr"); flag == nil || flag.Name != "jq" {
+		t.Errorf("--expr is %+v, want the --jq flag", flag)
+	}
+}
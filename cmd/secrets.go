@@ -0,0 +1,233 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/99designs/keyring"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/rafahpe/cpcli/term"
+	"github.com/spf13/viper"
+)
+
+// Credential backends, selected with the 'secrets' config variable.
+const (
+	// SecretsKeyring stores credentials in the OS secret store
+	// (Secret Service / KWallet on Linux, Keychain on macOS, WinCred on Windows)
+	SecretsKeyring = "keyring"
+	// SecretsFile stores credentials in files encrypted with a passphrase
+	SecretsFile = "file"
+	// SecretsPlain stores credentials in plaintext in the config file
+	SecretsPlain = "plain"
+)
+
+// ErrUnknownSecrets returned when the credential backend is not supported
+const ErrUnknownSecrets = Error("Unknown credential backend, must be one of 'keyring', 'file' or 'plain'")
+
+// Service name for the keyring items
+const secretService = "cpcli"
+
+// secretStore saves and retrieves credentials of the active profile
+type secretStore interface {
+	// Get returns the secret, "" if not found.
+	Get(name string) (string, error)
+	// Set stores the secret. Empty value removes it.
+	Set(name, value string) error
+}
+
+// plainStore keeps secrets in plaintext in the config file
+type plainStore struct {
+	master *Master
+}
+
+// keyringStore keeps secrets in a keyring backend. The keyring is
+// shared by all the config files, so the items are told apart by
+// the server and client of the profile, besides its name.
+type keyringStore struct {
+	ring    keyring.Keyring
+	profile string
+	server  string
+	client  string
+}
+
+// openSecrets opens the credential backend configured for the active profile
+func (master *Master) openSecrets() (secretStore, error) {
+	backend := master.getString("secrets")
+	config := keyring.Config{ServiceName: secretService}
+	switch backend {
+	case "", SecretsKeyring:
+		config.AllowedBackends = []keyring.BackendType{
+			keyring.SecretServiceBackend,
+			keyring.KWalletBackend,
+			keyring.KeychainBackend,
+			keyring.WinCredBackend,
+		}
+	case SecretsFile:
+		home, err := homedir.Dir()
+		if err != nil {
+			return nil, err
+		}
+		config.AllowedBackends = []keyring.BackendType{keyring.FileBackend}
+		config.FileDir = path.Join(home, ".cpcli-secrets")
+		config.FilePasswordFunc = passphrase
+	case SecretsPlain:
+		return plainStore{master: master}, nil
+	default:
+		return nil, ErrUnknownSecrets
+	}
+	ring, err := keyring.Open(config)
+	if err != nil {
+		if backend == "" {
+			backend = SecretsKeyring
+		}
+		return nil, fmt.Errorf("Could not open '%s' credential backend: %s. Use --secrets file to save credentials encrypted with a passphrase, or --secrets plain to save them in the config file", backend, err)
+	}
+	return keyringStore{ring: ring, profile: master.Profile, server: master.getString("server"), client: master.getString("client")}, nil
+}
+
+// passphrase for the encrypted file backend, from CPPM_PASSPHRASE or prompt
+func passphrase(prompt string) (string, error) {
+	if pass, ok := os.LookupEnv("CPPM_PASSPHRASE"); ok {
+		return pass, nil
+	}
+	return term.Readline(prompt+": ", true)
+}
+
+// getSecret returns a credential. Values given in the command line or
+// environment take precedence, then the credential backend. Plaintext values
+// in the config file are still honored, so old config files keep working.
+func (master *Master) getSecret(name string) string {
+	if overridden(name) || master.secrets == nil {
+		return master.getString(name)
+	}
+	value, err := master.secrets.Get(name)
+	if err != nil {
+		master.Log.Print("Error reading ", name, " from credential backend: ", err)
+	}
+	if value == "" {
+		value = master.getString(name)
+	}
+	return value
+}
+
+// setSecret stores a credential in the backend, and removes
+// any plaintext copy from the config file.
+func (master *Master) setSecret(name, value string) error {
+	if master.secrets == nil {
+		return master.secretsErr
+	}
+	if err := master.secrets.Set(name, value); err != nil {
+		return err
+	}
+	if _, plain := master.secrets.(plainStore); !plain {
+		master.set(name, "")
+	}
+	return nil
+}
+
+// migrateSecrets moves the plaintext credentials saved in the config
+// file by older versions into the credential backend.
+func (master *Master) migrateSecrets() error {
+	if _, plain := master.secrets.(plainStore); plain {
+		return nil
+	}
+	migrated := false
+	for _, name := range []string{"token", "refresh", "cookie"} {
+		value := master.getString(name)
+		if value == "" || overridden(name) {
+			continue
+		}
+		// The backend may already hold a newer value
+		stored, err := master.secrets.Get(name)
+		if err != nil {
+			return err
+		}
+		if stored != "" {
+			master.set(name, "")
+		} else if err := master.setSecret(name, value); err != nil {
+			return err
+		}
+		migrated = true
+	}
+	if !migrated {
+		return nil
+	}
+	return master.writeConfig()
+}
+
+// Get implements secretStore
+func (s plainStore) Get(name string) (string, error) {
+	return s.master.getString(name), nil
+}
+
+// Set implements secretStore
+func (s plainStore) Set(name, value string) error {
+	s.master.set(name, value)
+	return nil
+}
+
+// key returns the name of the keyring item for the active profile,
+// e.g. "lab.token/cpcli@cppm.example.com"
+func (s keyringStore) key(name string) string {
+	if s.profile != "" {
+		name = s.profile + "." + name
+	}
+	if s.server == "" && s.client == "" {
+		return name
+	}
+	return fmt.Sprintf("%s/%s@%s", name, s.client, s.server)
+}
+
+// Get implements secretStore
+func (s keyringStore) Get(name string) (string, error) {
+	item, err := s.ring.Get(s.key(name))
+	if err == keyring.ErrKeyNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(item.Data), nil
+}
+
+// Set implements secretStore
+func (s keyringStore) Set(name, value string) error {
+	key := s.key(name)
+	if value == "" {
+		err := s.ring.Remove(key)
+		if err == keyring.ErrKeyNotFound || os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return s.ring.Set(keyring.Item{
+		Key:   key,
+		Data:  []byte(value),
+		Label: fmt.Sprintf("%s %s", secretService, key),
+	})
+}
+
+// writeConfig saves the settings updated with set to the config file.
+// Settings from flags or the environment are not written, unless they
+// were stored in the profile. The file may hold plaintext secrets, even
+// from older versions, so make sure only the owner can read it.
+func (master *Master) writeConfig() error {
+	name := viper.ConfigFileUsed()
+	file := viper.New()
+	file.SetConfigFile(name)
+	if filepath.Ext(name) == "" {
+		file.SetConfigType("yaml")
+	}
+	if err := file.ReadInConfig(); err != nil {
+		return err
+	}
+	for key, value := range master.changes {
+		file.Set(key, value)
+	}
+	if err := file.WriteConfig(); err != nil {
+		return err
+	}
+	return os.Chmod(name, 0600)
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/99designs/keyring"
+	"github.com/spf13/viper"
+)
+
+func TestKeyringStore(t *testing.T) {
+	tests := []struct {
+		profile string
+		server  string
+		name    string
+		item    string
+	}{
+		{"", "", "token", "token"},
+		{"lab", "", "token", "lab.token"},
+		{"", "cppm.example.com", "token", "token/cpcli@cppm.example.com"},
+		{"production", "cppm.example.com", "cookie", "production.cookie/cpcli@cppm.example.com"},
+	}
+	for _, test := range tests {
+		ring := keyring.NewArrayKeyring(nil)
+		store := keyringStore{ring: ring, profile: test.profile, server: test.server}
+		if test.server != "" {
+			store.client = "cpcli"
+		}
+		if key := store.key(test.name); key != test.item {
+			t.Errorf("key(%q) in profile %q = %q, want %q", test.name, test.profile, key, test.item)
+		}
+		if err := store.Set(test.name, "secret"); err != nil {
+			t.Fatal(err)
+		}
+		if item, err := ring.Get(test.item); err != nil || string(item.Data) != "secret" {
+			t.Errorf("item %q = %v, %v, want the secret", test.item, item, err)
+		}
+		if value, err := store.Get(test.name); err != nil || value != "secret" {
+			t.Errorf("Get(%q) = %q, %v, want the secret", test.name, value, err)
+		}
+		if err := store.Set(test.name, ""); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := store.Get(test.name); err != nil || value != "" {
+			t.Errorf("Get(%q) after removal = %q, %v, want empty", test.name, value, err)
+		}
+		// Removing a missing secret is not an error
+		if err := store.Set(test.name, ""); err != nil {
+			t.Errorf("Set(%q, \"\") of a missing secret = %v", test.name, err)
+		}
+	}
+}
+
+func TestKeyringStoreServers(t *testing.T) {
+	ring := keyring.NewArrayKeyring(nil)
+	lab := keyringStore{ring: ring, server: "lab.example.com", client: "cpcli"}
+	production := keyringStore{ring: ring, server: "cppm.example.com", client: "cpcli"}
+	if err := lab.Set("token", "lab"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := production.Get("token"); err != nil || value != "" {
+		t.Errorf("token of another server = %q, %v, want empty", value, err)
+	}
+}
+
+func TestMigrateSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		plain   bool   // Target backend is the config file
+		config  string // Token in the config file
+		stored  string // Token in the backend
+		want    string // Token in the backend after migration
+		cleared bool   // Token removed from the config file
+	}{
+		{"to keyring", false, "abc", "", "abc", true},
+		{"already migrated", false, "old", "new", "new", true},
+		{"nothing to migrate", false, "", "", "", false},
+		{"to config file", true, "abc", "", "abc", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			name := filepath.Join(t.TempDir(), "cpcli.yaml")
+			config := "server: cppm.example.com\n"
+			if test.config != "" {
+				config += "token: " + test.config + "\n"
+			}
+			if err := ioutil.WriteFile(name, []byte(config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			viper.SetConfigFile(name)
+			if err := viper.ReadInConfig(); err != nil {
+				t.Fatal(err)
+			}
+			master := &Master{Log: log.New(&bytes.Buffer{}, "", 0)}
+			if test.plain {
+				master.secrets = plainStore{master: master}
+			} else {
+				ring := keyring.NewArrayKeyring(nil)
+				if test.stored != "" {
+					ring.Set(keyring.Item{Key: "token", Data: []byte(test.stored)})
+				}
+				master.secrets = keyringStore{ring: ring}
+			}
+			if err := master.migrateSecrets(); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := master.secrets.Get("token"); got != test.want {
+				t.Errorf("token in the backend = %q, want %q", got, test.want)
+			}
+			file := viper.New()
+			file.SetConfigFile(name)
+			if err := file.ReadInConfig(); err != nil {
+				t.Fatal(err)
+			}
+			if cleared := file.GetString("token") == ""; cleared != (test.cleared || test.config == "") {
+				t.Errorf("token in the config file = %q, cleared = %v", file.GetString("token"), test.cleared)
+			}
+			if file.GetString("server") != "cppm.example.com" {
+				t.Error("other settings lost from the config file")
+			}
+			if info, err := os.Stat(name); err == nil && test.cleared && info.Mode().Perm() != 0600 {
+				t.Errorf("config file mode = %v, want 0600", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestSetSecretWithoutBackend(t *testing.T) {
+	master := &Master{secretsErr: Error("no keyring")}
+	if err := master.setSecret("token", "abc"); err != master.secretsErr {
+		t.Errorf("setSecret without backend = %v, want the backend error", err)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright © 2017 Rafael Rivero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// Folder to write the snapshot to, and whether to keep the secrets
+var (
+	snapshotFolder  string
+	snapshotSecrets bool
+)
+
+// snapshotCmd represents the snapshot command
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot --out <folder> [type...]",
+	Short: "Save the configuration objects to a folder, one file per object",
+	Long: `Save the configuration objects available through the REST API to a
+folder, to keep them under version control or compare them with "diff".
+
+  - All the known types are saved, unless some are given: static-host-list,
+    role, role-mapping, enforcement-profile, enforcement-policy,
+    network-device, network-device-group, local-user, api-client and
+    guest-settings. Endpoints are only saved if given ("endpoint").
+    Services are only available in the Web UI, use "export" for them.
+  - Each object is written to <folder>/<path>/<key>.json, named after its
+    natural key, with sorted attributes and without id and links, so the
+    changes between snapshots are easy to review. Use -o yaml for YAML files.
+  - Secret attributes (passwords, shared secrets, tokens...) are not saved,
+    unless --keep-secrets is given. Mind who can read the folder then.
+  - Files of objects that no longer exist are removed.
+  - <folder>/index.json records the server, its version, the time of the
+    snapshot and the number of objects of each type.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := Singleton.Snapshot(snapshotFolder, args, snapshotSecrets); err != nil {
+			Singleton.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.Flags().StringVar(&snapshotFolder, "out", "", "Folder to write the snapshot to, created if needed")
+	snapshotCmd.Flags().BoolVar(&snapshotSecrets, "keep-secrets", false, "Save secret attributes too, e.g. RADIUS shared secrets and passwords")
+}
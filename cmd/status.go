@@ -0,0 +1,96 @@
+// Copyright © 2017 Rafael Rivero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:     "status",
+	Aliases: []string{"whoami"},
+	Short:   "Show the current session",
+	Long: `Show the current session: server, client ID, user, token expiration,
+privileges, web session validity and server version.
+
+  - Use -o json to get the status as a JSON object.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := Singleton.Status()
+		if err != nil {
+			Singleton.Fatal(errors.Wrap(err, "Status error"))
+		}
+		if Singleton.Options.Output == "json" {
+			var output []byte
+			if Singleton.Options.PrettyPrint {
+				output, err = json.MarshalIndent(status, "", "  ")
+			} else {
+				output, err = json.Marshal(status)
+			}
+			if err != nil {
+				Singleton.Fatal(err)
+			}
+			fmt.Println(string(output))
+			return
+		}
+		printStatus(status)
+	},
+}
+
+// Describe the validity of a session
+func valid(ok bool) string {
+	if ok {
+		return "valid"
+	}
+	return "not valid"
+}
+
+// Print the status in human-readable format
+func printStatus(status Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Server:\t%s\n", status.Server)
+	if status.Profile != "" {
+		fmt.Fprintf(w, "Profile:\t%s\n", status.Profile)
+	}
+	fmt.Fprintf(w, "Client ID:\t%s\n", status.Client)
+	fmt.Fprintf(w, "User:\t%s\n", status.User)
+	session := valid(status.APISession)
+	if status.APISession && status.Expires != nil {
+		session = fmt.Sprintf("%s, expires in %s (%s)", session,
+			time.Until(*status.Expires).Round(time.Second), status.Expires.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "API session:\t%s\n", session)
+	if len(status.Privileges) > 0 {
+		fmt.Fprintf(w, "Privileges:\t%s\n", strings.Join(status.Privileges, "\n\t"))
+	}
+	fmt.Fprintf(w, "Web session:\t%s\n", valid(status.WebSession))
+	if status.Version != "" {
+		fmt.Fprintf(w, "Server version:\t%s\n", status.Version)
+	}
+	w.Flush()
+}
+
+func init() {
+	RootCmd.AddCommand(statusCmd)
+}
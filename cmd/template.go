@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/rafahpe/cpcli/model"
+)
+
+// ErrTemplateBody returned when the path or query are templates,
+// but the body is not a JSON object
+const ErrTemplateBody = Error("Path and query templates need a JSON object as request body")
+
+// requestTemplate renders the path and query of each request
+// from the attributes of the body, e.g. "endpoint/{{.id}}"
+type requestTemplate struct {
+	path   *template.Template
+	query  map[string]*template.Template
+	fields [][]string // Attributes used by the templates
+}
+
+// isTemplate checks if the text has template actions
+func isTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
+
+// newRequestTemplate parses the path and query values.
+// Returns nil if none of them is a template.
+func newRequestTemplate(path string, query model.Params) (*requestTemplate, error) {
+	result := &requestTemplate{query: make(map[string]*template.Template)}
+	found := false
+	compile := func(name, text string) (*template.Template, error) {
+		if !isTemplate(text) {
+			return nil, nil
+		}
+		found = true
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		result.fields = append(result.fields, templateFields(tmpl.Tree.Root)...)
+		return tmpl, nil
+	}
+	var err error
+	if result.path, err = compile("path", path); err != nil {
+		return nil, err
+	}
+	for key, val := range query {
+		tmpl, err := compile(key, val)
+		if err != nil {
+			return nil, err
+		}
+		if tmpl != nil {
+			result.query[key] = tmpl
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// templateFields returns the attributes used in the template,
+// e.g. ["attributes", "Owner"] for "{{.attributes.Owner}}"
+func templateFields(node parse.Node) [][]string {
+	fields := make([][]string, 0, 4)
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node != nil {
+			for _, item := range node.Nodes {
+				fields = append(fields, templateFields(item)...)
+			}
+		}
+	case *parse.ActionNode:
+		fields = append(fields, templateFields(node.Pipe)...)
+	case *parse.PipeNode:
+		if node != nil {
+			for _, cmd := range node.Cmds {
+				fields = append(fields, templateFields(cmd)...)
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			fields = append(fields, templateFields(arg)...)
+		}
+	case *parse.FieldNode:
+		fields = append(fields, node.Ident)
+	case *parse.IfNode:
+		fields = append(fields, templateBranch(node.BranchNode)...)
+	case *parse.RangeNode:
+		fields = append(fields, templateBranch(node.BranchNode)...)
+	case *parse.WithNode:
+		fields = append(fields, templateBranch(node.BranchNode)...)
+	}
+	return fields
+}
+
+// templateBranch returns the attributes used in an if, range or with
+func templateBranch(node parse.BranchNode) [][]string {
+	fields := templateFields(node.Pipe)
+	fields = append(fields, templateFields(node.List)...)
+	return append(fields, templateFields(node.ElseList)...)
+}
+
+// escapePath escapes the strings in the value, to be used in a URL path
+func escapePath(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return url.PathEscape(value)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			result[key] = escapePath(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			result = append(result, escapePath(item))
+		}
+		return result
+	}
+	return value
+}
+
+// filterParam is the query parameter with a JSON filter. String values
+// rendered inside it are JSON-escaped, so quotes don't break the filter.
+const filterParam = "filter"
+
+// escapeJSON escapes the strings in the value, to be used inside JSON strings
+func escapeJSON(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		quoted, err := json.Marshal(value)
+		if err != nil {
+			return value
+		}
+		return string(quoted[1 : len(quoted)-1])
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			result[key] = escapeJSON(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			result = append(result, escapeJSON(item))
+		}
+		return result
+	}
+	return value
+}
+
+// strip removes the attribute from the object
+func strip(object map[string]interface{}, field []string) {
+	for _, step := range field[:len(field)-1] {
+		inner, ok := object[step].(map[string]interface{})
+		if !ok {
+			return
+		}
+		object = inner
+	}
+	delete(object, field[len(field)-1])
+}
+
+// execute renders the template with the given data
+func execute(tmpl *template.Template, data interface{}) (string, error) {
+	buffer := &bytes.Buffer{}
+	if err := tmpl.Execute(buffer, data); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// render returns the path, query and body of the request for the given body.
+// If stripFields, the attributes used by the templates are removed from the body.
+func (t *requestTemplate) render(path string, query model.Params, body interface{}, stripFields bool) (string, model.Params, interface{}, error) {
+	raw, ok := body.(json.RawMessage)
+	if !ok {
+		return "", nil, nil, ErrTemplateBody
+	}
+	var data map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil || data == nil {
+		return "", nil, nil, ErrTemplateBody
+	}
+	var err error
+	if t.path != nil {
+		if path, err = execute(t.path, escapePath(data)); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	if len(t.query) > 0 {
+		rendered := make(model.Params, len(query))
+		for key, val := range query {
+			if tmpl, ok := t.query[key]; ok {
+				var values interface{} = data
+				if key == filterParam {
+					values = escapeJSON(data)
+				}
+				if val, err = execute(tmpl, values); err != nil {
+					return "", nil, nil, err
+				}
+			}
+			rendered[key] = val
+		}
+		query = rendered
+	}
+	if stripFields {
+		for _, field := range t.fields {
+			strip(data, field)
+		}
+		if raw, err = json.Marshal(data); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	return path, query, raw, nil
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rafahpe/cpcli/model"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		path  string
+		query model.Params
+		body  string
+		strip bool
+		want  string
+		wantQ model.Params
+		wantB string
+	}{
+		{"endpoint/{{.id}}", nil, `{"id":12,"status":"Known"}`, false,
+			"endpoint/12", nil, `{"id":12,"status":"Known"}`},
+		{"local-user/user-id/{{.user_id}}", nil, `{"user_id":"a b/c"}`, false,
+			"local-user/user-id/a%20b%2Fc", nil, `{"user_id":"a b/c"}`},
+		{"endpoint/{{.attributes.Owner}}", nil, `{"attributes":{"Owner":"me","Site":"HQ"}}`, true,
+			"endpoint/me", nil, `{"attributes":{"Site":"HQ"}}`},
+		{"role", model.Params{"filter": `{"name":"{{.name}}"}`, "limit": "{{.limit}}"}, `{"name":"O\"Brien\\","limit":"a\"b"}`, true,
+			"role", model.Params{"filter": `{"name":"O\"Brien\\"}`, "limit": `a"b`}, `{}`},
+	}
+	for _, test := range tests {
+		tmpl, err := newRequestTemplate(test.path, test.query)
+		if err != nil || tmpl == nil {
+			t.Fatalf("newRequestTemplate(%q) = %v, %v", test.path, tmpl, err)
+		}
+		path, query, body, err := tmpl.render(test.path, test.query, json.RawMessage(test.body), test.strip)
+		if err != nil {
+			t.Errorf("render(%q, %s) error = %s", test.path, test.body, err)
+			continue
+		}
+		if path != test.want {
+			t.Errorf("render(%q, %s) path = %q, want %q", test.path, test.body, path, test.want)
+		}
+		for key, want := range test.wantQ {
+			if query[key] != want {
+				t.Errorf("render(%q, %s) query %s = %q, want %q", test.path, test.body, key, query[key], want)
+			}
+		}
+		if got := string(body.(json.RawMessage)); got != test.wantB {
+			t.Errorf("render(%q, %s) body = %s, want %s", test.path, test.body, got, test.wantB)
+		}
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	tmpl, err := newRequestTemplate("endpoint/{{.id}}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []interface{}{nil, json.RawMessage(`[1]`), json.RawMessage(`{"name":"a"}`)} {
+		if _, _, _, err := tmpl.render("endpoint/{{.id}}", nil, body, false); err == nil {
+			t.Errorf("render(%v) succeeded, want error", body)
+		}
+	}
+	if tmpl, err := newRequestTemplate("endpoint", model.Params{"limit": "10"}); tmpl != nil || err != nil {
+		t.Errorf("newRequestTemplate without actions = %v, %v, want nil", tmpl, err)
+	}
+	if _, err := newRequestTemplate("endpoint/{{.id", nil); err == nil {
+		t.Error("newRequestTemplate with a broken template succeeded")
+	}
+}
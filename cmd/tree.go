@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rafahpe/cpcli/model"
+	"github.com/rafahpe/cpcli/term"
+)
+
+const (
+	// ErrMissingFolder returned when no folder is provided for the snapshot
+	ErrMissingFolder = Error("No folder specified for the snapshot, use --out <folder>")
+	// ErrSnapshotFormat returned when the output format is not supported for snapshots
+	ErrSnapshotFormat = Error("Snapshots can only be written as json or yaml")
+)
+
+// snapshotIndex is the name of the index file in the snapshot folder
+const snapshotIndex = "index.json"
+
+// snapshotInfo is the index of a snapshot
+type snapshotInfo struct {
+	Server      string            `json:"server"`
+	Version     string            `json:"server_version"`
+	Time        time.Time         `json:"time"`
+	Format      string            `json:"format"`
+	Collections map[string]int    `json:"collections"`       // Number of objects, by path
+	Errors      map[string]string `json:"errors,omitempty"`  // Collections that could not be read
+	Secrets     bool              `json:"secrets,omitempty"` // Secret attributes were kept
+}
+
+// objectFile checks if the file may hold objects, by extension
+func objectFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml", ".hjson":
+		return true
+	}
+	return false
+}
+
+// fileName escapes the key of an object to be used as file name. Letters,
+// digits, '-', '_' and '.' are kept, the rest is %-encoded like URLs.
+func fileName(key string) string {
+	var name strings.Builder
+	for i, c := range []byte(key) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			name.WriteByte(c)
+		case c == '.' && i > 0:
+			// A leading dot would hide the file
+			name.WriteByte(c)
+		default:
+			fmt.Fprintf(&name, "%%%02X", c)
+		}
+	}
+	return name.String()
+}
+
+// dropSecrets removes the secret attributes (passwords, shared secrets...)
+// from the value, at any depth, so they don't end up in version control.
+// Only text is secret, e.g. "access_token_lifetime" is kept.
+func dropSecrets(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if _, text := item.(string); text && model.Sensitive(key) {
+				delete(value, key)
+				continue
+			}
+			value[key] = dropSecrets(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = dropSecrets(item)
+		}
+	}
+	return value
+}
+
+// readSnapshot reads the objects of the collection from a snapshot folder,
+// one object per file under a folder named after the path.
+func readSnapshot(folder, path string) ([]map[string]interface{}, error) {
+	dir := filepath.Join(folder, filepath.FromSlash(strings.Trim(path, "/")))
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' not found in snapshot %s", path, folder)
+	}
+	items := make([]map[string]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !objectFile(entry.Name()) {
+			continue
+		}
+		name := filepath.Join(dir, entry.Name())
+		input, err := term.Data("@"+name, "")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		for input.Next() {
+			item, err := decodeObject(input.Get())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+			items = append(items, item)
+		}
+		if err := input.Error(); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return items, nil
+}
+
+// writeObject writes the object to the file in the given format,
+// with keys sorted and without the attributes set by the server.
+func writeObject(ctx context.Context, name string, object map[string]interface{}, options term.Options) error {
+	data, err := json.Marshal(writable(object))
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return term.OutputTo(ctx, file, options, model.NewReply(data, nil), nil)
+}
+
+// snapshotResource writes the objects of the resource to a folder named
+// after its path, one file per object. Returns the number of objects.
+// Secrets are removed, unless keepSecrets.
+func (master *Master) snapshotResource(ctx context.Context, folder string, r resource, options term.Options, keepSecrets bool) (int, error) {
+	objects, err := collection(ctx, master.cppm, r.Path)
+	if err != nil {
+		return 0, err
+	}
+	dir := filepath.Join(folder, filepath.FromSlash(r.Path))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return 0, err
+	}
+	written := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		key, ok := naturalKey(object, r.Key)
+		if !ok {
+			master.Log.Printf("%s: object %v without '%s' skipped", r.Path, object["id"], r.Key)
+			continue
+		}
+		name := fileName(key) + "." + options.Output
+		if written[name] {
+			master.Log.Printf("%s: duplicate '%s' skipped", r.Path, key)
+			continue
+		}
+		written[name] = true
+		if !keepSecrets {
+			dropSecrets(object)
+		}
+		if err := writeObject(ctx, filepath.Join(dir, name), object, options); err != nil {
+			return 0, err
+		}
+	}
+	// Remove the objects deleted since the last snapshot
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && objectFile(entry.Name()) && !written[entry.Name()] {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(written), nil
+}
+
+// Snapshot writes the objects of the known resources (or the given types)
+// to the folder, one file per object, and an index with the server version
+// and the time of the snapshot. Secrets are only written if keepSecrets.
+func (master *Master) Snapshot(folder string, types []string, keepSecrets bool) error {
+	if folder == "" {
+		return ErrMissingFolder
+	}
+	format := master.Options.Output
+	switch format {
+	case "":
+		format = "json"
+	case "json", "yaml":
+	default:
+		return ErrSnapshotFormat
+	}
+	selected := make([]resource, 0, len(resources))
+	for _, r := range resources {
+		if !r.Data {
+			selected = append(selected, r)
+		}
+	}
+	if len(types) > 0 {
+		selected = selected[:0]
+		for _, name := range types {
+			r, ok := findResource(name)
+			if !ok {
+				return fmt.Errorf("Unknown type '%s', must be one of %s", name, strings.Join(resourceTypes(), ", "))
+			}
+			selected = append(selected, r)
+		}
+	}
+	if err := os.MkdirAll(folder, 0700); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	version, err := master.version(ctx)
+	if err != nil {
+		return err
+	}
+	info := snapshotInfo{
+		Server:      master.getString("server"),
+		Version:     version,
+		Time:        time.Now().UTC().Truncate(time.Second),
+		Format:      format,
+		Collections: make(map[string]int, len(selected)),
+		Secrets:     keepSecrets,
+	}
+	options := term.Options{Output: format, PrettyPrint: true}
+	succeeded, failed, total := 0, 0, 0
+	for _, r := range selected {
+		count, err := master.snapshotResource(ctx, folder, r, options, keepSecrets)
+		if err != nil {
+			failed++
+			if info.Errors == nil {
+				info.Errors = make(map[string]string)
+			}
+			info.Errors[r.Path] = err.Error()
+			master.report(errors.Wrap(err, r.Path), 0)
+			continue
+		}
+		succeeded++
+		total += count
+		info.Collections[r.Path] = count
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(folder, snapshotIndex), append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	master.Log.Printf("%d objects from %d collections written to %s", total, succeeded, folder)
+	if failed > 0 {
+		return BulkError{Succeeded: succeeded, Failed: failed}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFileName(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"Contractor", "Contractor"},
+		{"guest-role_2", "guest-role_2"},
+		{"10.0.0.1", "10.0.0.1"},
+		{"10.0.0.0/24", "10.0.0.0%2F24"},
+		{"[Guest]", "%5BGuest%5D"},
+		{"a b", "a%20b"},
+		{".hidden", "%2Ehidden"},
+		{"..", "%2E."},
+		{"50%", "50%25"},
+		{"día", "d%C3%ADa"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := fileName(test.key); got != test.want {
+			t.Errorf("fileName(%q) = %q, want %q", test.key, got, test.want)
+		}
+	}
+}
+
+func TestDropSecrets(t *testing.T) {
+	tests := []struct {
+		object string
+		want   string
+	}{
+		{`{"name":"sw","radius_secret":"s","tacacs_secret":"t"}`, `{"name":"sw"}`},
+		{`{"client_id":"c","client_secret":"s","access_token_lifetime":8}`, `{"access_token_lifetime":8,"client_id":"c"}`},
+		{`{"attributes":{"Password":"p","Owner":"me"}}`, `{"attributes":{"Owner":"me"}}`},
+		{`{"snmp":[{"community_string":"public","version":"v2"}]}`, `{"snmp":[{"version":"v2"}]}`},
+		{`{"password_policy":{"length":8}}`, `{"password_policy":{"length":8}}`},
+	}
+	for _, test := range tests {
+		object, err := decodeObject(json.RawMessage(test.object))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := json.Marshal(dropSecrets(object))
+		if string(got) != test.want {
+			t.Errorf("dropSecrets(%s) = %s, want %s", test.object, got, test.want)
+		}
+	}
+}
@@ -15,6 +15,10 @@
 package cmd
 
 import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/rafahpe/cpcli/model"
 	"github.com/spf13/cobra"
 	// Using this forst instead of the original because of write file support
 	// See: https://github.com/spf13/viper/pull/287
@@ -29,17 +33,20 @@ var webLoginCmd = &cobra.Command{
 	Long: `Logs into the CPPM HTTP interface using cached cookies, or providing an username and password to reauthenticate.
 
   - The ClearPass server address is provided in the 'server' configuration variable, CPPM_SERVER environment variable, or with the -h flag.
-  - The username is provided with the 'user' config variable, CPPM_USER environment variable, or -u flag`,
+  - The username is provided with the 'user' config variable, CPPM_USER environment variable, or -u flag
+  - When a profile is selected with the -C flag or CPPM_PROFILE environment variable, settings are read from and saved to that profile in the 'profiles' section of the config file.
+  - Tokens and cookies are kept in the credential backend selected with the 'secrets' config variable or --secrets flag: 'keyring' (OS secret store, default), 'file' (encrypted with the passphrase in CPPM_PASSPHRASE, or prompted) or 'plain' (config file, readable only by the owner).
+  - Client secret and password are read from the CPPM_SECRET and CPPM_PASSWORD environment variables, the --secret-file or --secret-stdin flags ('secret=...' and 'password=...' lines), or the command in the 'credential-helper' config variable (git-credential style). If none of them is provided, they are prompted.`,
 	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Log to Stderr without timestamps
 		if cookie, err := Singleton.WebLogin(); err != nil {
-			Singleton.Log.Fatal("WebLogin error: ", err)
+			Singleton.Fatal(errors.Wrap(err, "WebLogin error"))
 		} else {
 			if err := Singleton.SaveCookie(cookie); err != nil {
-				Singleton.Log.Fatal("WebLogin Error saving config data: ", err)
+				Singleton.Fatal(errors.Wrap(err, "WebLogin Error saving config data"))
 			}
-			Singleton.Log.Println("WebLogin OK. Cookie: ", cookie)
+			Singleton.Log.Println("WebLogin OK. Cookie: ", model.Mask(fmt.Sprint(cookie)))
 		}
 	},
 }
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	// Using this fork instead of the original because of write file support
 	// See: https://github.com/spf13/viper/pull/287
@@ -31,7 +32,7 @@ var webLogoutCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		// Log to Stderr without timestamps
 		if err := Singleton.WebLogout(); err != nil {
-			Singleton.Log.Fatal("WebLogout error: ", err)
+			Singleton.Fatal(errors.Wrap(err, "WebLogout error"))
 		}
 		Singleton.Log.Print("Logout completed")
 	},
@@ -2,11 +2,18 @@ package cmd
 
 import (
 	"encoding/json"
+	"strconv"
 	"strings"
 
 	hjson "github.com/hjson/hjson-go"
 )
 
+// Max page size accepted by the API
+const maxLimit = 1000
+
+// ErrLimit returned when the page size is above the max of the API
+const ErrLimit = Error("The --limit or limit query param can't be above 1000, the max page size of the API. Use --max to stop after more items")
+
 func (master *Master) readQuery() (map[string]string, error) {
 	query := make(map[string]string)
 	if master.Query != nil && len(master.Query) > 0 {
@@ -19,8 +26,9 @@ func (master *Master) readQuery() (map[string]string, error) {
 			} else {
 				key := strings.TrimSpace(parts[0])
 				val := strings.TrimSpace(parts[1])
-				// If val has json format, parse it using hjson and normalize back to standard json
-				if strings.HasPrefix(val, "{") {
+				// If val has json format, parse it using hjson and normalize back to standard json.
+				// Templates like "{{.name}}" are filled later, for each request body.
+				if strings.HasPrefix(val, "{") && !strings.HasPrefix(val, "{{") {
 					var obj interface{}
 					if err := hjson.Unmarshal([]byte(val), &obj); err != nil {
 						return nil, err
@@ -35,5 +43,24 @@ func (master *Master) readQuery() (map[string]string, error) {
 			}
 		}
 	}
+	// Paging flags take precedence over query params
+	if master.Limit > maxLimit {
+		return nil, ErrLimit
+	}
+	if limit, err := strconv.Atoi(query["limit"]); err == nil && limit > maxLimit && master.Limit <= 0 {
+		return nil, ErrLimit
+	}
+	if master.Limit > 0 {
+		query["limit"] = strconv.Itoa(master.Limit)
+	} else if _, ok := query["limit"]; !ok && master.Max > 0 && master.Max <= maxLimit {
+		// Don't ask for more than needed
+		query["limit"] = strconv.Itoa(master.Max)
+	}
+	if master.Offset > 0 {
+		query["offset"] = strconv.Itoa(master.Offset)
+	}
+	if master.Sort != "" {
+		query["sort"] = master.Sort
+	}
 	return query, nil
 }
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  []string
+		limit  int
+		offset int
+		sort   string
+		max    int
+		want   map[string]string
+		err    error
+	}{
+		{"empty", nil, 0, 0, "", 0, map[string]string{}, nil},
+		{"exists filter", []string{"mac"}, 0, 0, "", 0, map[string]string{"mac": "{'$exists': true}"}, nil},
+		{"hjson filter", []string{"filter={name: 'x'}"}, 0, 0, "", 0, map[string]string{"filter": `{"name":"x"}`}, nil},
+		{"template filter", []string{"filter={{.name}}"}, 0, 0, "", 0, map[string]string{"filter": "{{.name}}"}, nil},
+		{"limit", nil, 50, 0, "", 0, map[string]string{"limit": "50"}, nil},
+		{"limit above max", nil, 1001, 0, "", 0, nil, ErrLimit},
+		{"max as limit", nil, 0, 0, "", 10, map[string]string{"limit": "10"}, nil},
+		{"max smaller than limit", nil, 100, 0, "", 10, map[string]string{"limit": "100"}, nil},
+		{"max above page size", nil, 0, 0, "", 5000, map[string]string{}, nil},
+		{"max with query limit", []string{"limit=5"}, 0, 0, "", 10, map[string]string{"limit": "5"}, nil},
+		{"limit overrides query", []string{"limit=5"}, 20, 0, "", 0, map[string]string{"limit": "20"}, nil},
+		{"query limit above max", []string{"limit=5000"}, 0, 0, "", 0, nil, ErrLimit},
+		{"limit overrides query above max", []string{"limit=5000"}, 20, 0, "", 0, map[string]string{"limit": "20"}, nil},
+		{"offset and sort", nil, 0, 20, "-id", 0, map[string]string{"offset": "20", "sort": "-id"}, nil},
+		{"sort overrides query", []string{"sort=+id"}, 0, 0, "-name", 0, map[string]string{"sort": "-name"}, nil},
+	}
+	for _, test := range tests {
+		master := &Master{Query: test.query, Limit: test.limit, Offset: test.offset, Sort: test.sort, Max: test.max}
+		got, err := master.readQuery()
+		if err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
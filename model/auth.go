@@ -1,251 +1,373 @@
-package model
-
-import (
-	"context"
-	"errors"
-	"log"
-	"net/http"
-	"net/http/cookiejar"
-	"net/url"
-	"strings"
-
-	"golang.org/x/net/publicsuffix"
-)
-
-// Authentication request
-type authRequest map[string]string
-
-// Authentication Reply
-type authReply struct {
-	Token   string `json:"access_token"`
-	Refresh string `json:"refresh_token"`
-	Expires int    `json:"expires_in"`
-	Type    string `json:"token_type"`
-	Scope   string `json:"scope"`
-}
-
-// Perform an authentication request
-func (c *clearpass) auth(ctx context.Context, address string, req authRequest) (string, string, error) {
-	baseURL := apiURL(address)
-	fullURL := baseURL + "/oauth"
-	rep := authReply{}
-	if err := rest(ctx, c.client, POST, fullURL, "", nil, req, &rep); err != nil {
-		return "", "", err
-	}
-	c.apiURL, c.webURL, c.token, c.refresh = baseURL, webURL(address), rep.Token, rep.Refresh
-	return c.token, c.refresh, nil
-}
-
-// Login into clearpass with the provided credentials, return token.
-func (c *clearpass) Login(ctx context.Context, address, clientID, secret, username, pass string) (string, string, error) {
-	req := authRequest{
-		"grant_type": "client_credentials",
-		"client_id":  clientID,
-	}
-	if secret != "" {
-		req["client_secret"] = secret
-	}
-	if username != "" && pass != "" {
-		req["grant_type"] = "password"
-		req["username"] = username
-		req["password"] = pass
-	}
-	return c.auth(ctx, address, req)
-}
-
-// Validate the token is still useful
-func (c *clearpass) Validate(ctx context.Context, address, clientID, secret, token, refresh string) (string, string, error) {
-	// If there is a refresh token, try to refresh auth
-	if refresh != "" {
-		req := authRequest{
-			"grant_type":    "refresh_token",
-			"client_id":     clientID,
-			"refresh_token": refresh,
-			"client_secret": secret,
-		}
-		t, r, err := c.auth(ctx, address, req)
-		if err == nil {
-			return t, r, err
-		}
-	}
-	// No refresh or it didn't succeed, just check the
-	// current token is still valid.
-	baseURL := apiURL(address)
-	fullURL := baseURL + "/api-client/" + url.PathEscape(clientID)
-	var rep RawReply
-	if err := rest(ctx, c.client, GET, fullURL, token, nil, nil, &rep); err != nil {
-		return "", "", err
-	}
-	c.apiURL, c.webURL, c.token, c.refresh = baseURL, webURL(address), token, ""
-	return c.token, c.refresh, nil
-}
-
-// WebLogin into clearpass with the provided credentials, return cookies.
-func (c *clearpass) WebLogin(ctx context.Context, address, username, pass string) ([]*http.Cookie, error) {
-	baseURL := webURL(address)
-	cookieURL, err := url.Parse(baseURL)
-	if err != nil {
-		return nil, err
-	}
-	// Reset the cookie jar
-	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
-	if err != nil {
-		log.Print("Error creating cookieJar, will not be able to use web login: ", err)
-		jar = nil
-	}
-	c.client.Jar = jar
-	// Get the first cookie
-	fullURL := baseURL + "/tipsLogin.action"
-	req, err := http.NewRequest(string(GET), fullURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	detail, _ := rawRequest(ctx, c.client, req, nil, false)
-	if detail.Err != nil {
-		return nil, err
-	}
-	// Get the DWR cookie
-	fullURL = baseURL + "/dwr/call/plaincall/__System.generateId.dwr"
-	dwrBody := "callCount=1\nc0-scriptName=__System\nc0-methodName=generateId\nc0-id=0\nbatchId=0\ninstanceId=0\npage=%2Ftips%2FtipsLogin.action\nscriptSessionId=\n"
-	req, err = http.NewRequest(string(POST), fullURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "text/plain")
-	detail, _ = rawRequest(ctx, c.client, req, []byte(dwrBody), false)
-	if detail.Err != nil {
-		return nil, detail
-	}
-	if detail.StatusCode != 200 {
-		detail.Err = errors.New("Failed to get dwr cookie with status != 200")
-		return nil, detail
-	}
-	// Parse the response and add the DWR cookie to the jar
-	dwrCookie := ""
-	parts := strings.SplitN(string(detail.Reply), "dwr.engine.remote.handleCallback(", 2)
-	if len(parts) > 1 {
-		parts = strings.SplitN(parts[1], "\"", 7)
-		if len(parts) > 5 {
-			cookies := c.client.Jar.Cookies(cookieURL)
-			dwrCookie = parts[5]
-			cookies = append(cookies, &http.Cookie{Name: dwrSessionCookie, Value: dwrCookie})
-			jar.SetCookies(cookieURL, cookies)
-		}
-	}
-	// Send the second pointless xhr request
-	fullURL = baseURL + "/dwr/call/plaincall/beforeLogin.getPublisherUrl.dwr"
-	dwrBody = "callCount=1\nnextReverseAjaxIndex=0\nc0-scriptName=beforeLogin\nc0-methodName=getPublisherUrl\nc0-id=0\nbatchId=1\ninstanceId=0\npage=%2Ftips%2FtipsLogin.action\nscriptSessionId=" + dwrCookie + "\n"
-	req, err = http.NewRequest(string(POST), fullURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "text/plain")
-	detail, _ = rawRequest(ctx, c.client, req, []byte(dwrBody), false)
-	if detail.Err != nil {
-		return nil, detail
-	}
-	if detail.StatusCode != 200 {
-		detail.Err = errors.New("Failed to confirm dwr cookie with status != 200")
-		return nil, detail
-	}
-	// Post the login data
-	fullURL = baseURL + "/tipsLoginSubmit.action"
-	data := make(url.Values)
-	data.Add("F_password", "0")
-	data.Add("username", username)
-	data.Add("password", pass)
-	req, err = http.NewRequest(string(POST), fullURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	detail, _ = rawRequest(ctx, c.client, req, []byte(data.Encode()), false)
-	if detail.Err != nil {
-		return nil, detail
-	}
-	// Finally, validate
-	cookies, err := c.WebValidate(ctx, address)
-	if err != nil {
-		return nil, err
-	}
-	c.webURL, c.apiURL = baseURL, apiURL(address)
-	return cookies, nil
-}
-
-// WebValidate checks the cookie is still useful
-func (c *clearpass) WebValidate(ctx context.Context, address string) ([]*http.Cookie, error) {
-	baseURL := webURL(address)
-	fullURL := baseURL + "/tipsContent.action"
-	req, err := http.NewRequest(string(GET), fullURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("Referer", baseURL+"/tipsLogin.action")
-	req.Header.Add("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-	req.Header.Add("Accept-Encoding", "gzip")
-	req.Header.Add("Accept-Encoding", "deflate")
-	req.Header.Add("Accept-Encoding", "br")
-	req.Header.Add("Upgrade-Insecure-Requests", "1")
-	detail, _ := rawRequest(ctx, c.client, req, nil, false)
-	if detail.Err != nil {
-		return nil, detail
-	}
-	cookies := c.cookies(baseURL)
-	if detail.StatusCode != 200 || cookies == nil || len(cookies) < 2 {
-		detail.Err = ErrNotLoggedIn
-		return nil, detail
-	}
-	return cookies, nil
-}
-
-// WebLogout from clearpass.
-// TODO: Check out. Does not seem to work.
-func (c *clearpass) WebLogout(ctx context.Context, address string) error {
-	baseURL := webURL(address)
-	// Find dwr session cookie
-	cookies := c.cookies(baseURL)
-	if cookies == nil {
-		return errors.New("Could not retrieve cookies")
-	}
-	dwrCookie := ""
-	for _, cookie := range cookies {
-		if cookie.Name == dwrSessionCookie {
-			dwrCookie = cookie.Value
-			break
-		}
-	}
-	if cookies == nil {
-		return errors.New("Could not retrieve DWR session cookie")
-	}
-	// Call XHR to close the session
-	fullURL := baseURL + "/dwr/call/plaincall/login.destroySession.dwr"
-	dwrBody := "callCount=1\nnextReverseAjaxIndex=0\nc0-scriptName=login\nc0-methodName=destroySession\nc0-id=0\nbatchId=1\ninstanceId=0\npage=%2Ftips%2FtipsContent.action\nscriptSessionId=" + dwrCookie + "\n"
-	req, err := http.NewRequest(string(POST), fullURL, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "text/plain")
-	detail, _ := rawRequest(ctx, c.client, req, []byte(dwrBody), false)
-	if detail.Err != nil {
-		return detail
-	}
-	if detail.StatusCode != 200 {
-		detail.Err = errors.New("Failed to close session with statuscode != 200")
-		return detail
-	}
-	// Call to checkStatus to make the logout effective
-	fullURL = baseURL + "/tipsLoginCheck.action"
-	req, err = http.NewRequest(string(GET), fullURL, nil)
-	if err != nil {
-		return err
-	}
-	detail, _ = rawRequest(ctx, c.client, req, nil, false)
-	if detail.Err != nil {
-		return detail
-	}
-	if detail.StatusCode != 302 {
-		detail.Err = errors.New("Did not get a redirect from tipsLoginCheck")
-		return detail
-	}
-	return nil
-}
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Authentication request
+type authRequest map[string]string
+
+// Authentication Reply
+type authReply struct {
+	Token   string `json:"access_token"`
+	Refresh string `json:"refresh_token"`
+	Expires int    `json:"expires_in"`
+	Type    string `json:"token_type"`
+	Scope   string `json:"scope"`
+}
+
+// Perform an authentication request
+func (c *clearpass) auth(ctx context.Context, address string, req authRequest) (string, string, error) {
+	baseURL := apiURL(address)
+	fullURL := baseURL + "/oauth"
+	rep := authReply{}
+	if err := c.retry.rest(ctx, c.client, POST, fullURL, "", nil, req, &rep); err != nil {
+		return "", "", err
+	}
+	c.address, c.apiURL, c.webURL = address, baseURL, webURL(address)
+	c.clientID, c.secret = req["client_id"], req["client_secret"]
+	c.token, c.expires = rep.Token, expiry(rep.Expires)
+	// The server may not rotate the refresh token. If so, keep the old one.
+	if rep.Refresh != "" || req["grant_type"] != "refresh_token" {
+		c.refresh = rep.Refresh
+	}
+	return c.token, c.refresh, nil
+}
+
+// expiry turns the "expires_in" seconds into a deadline. Zero if unknown.
+func expiry(seconds int) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(seconds) * time.Second)
+}
+
+// clientSecret returns the secret of the client: the one given, the one
+// used to log in, or else the one from the secret source, asked only once.
+// Empty for public clients.
+func (c *clearpass) clientSecret(secret string) (string, error) {
+	if secret != "" {
+		return secret, nil
+	}
+	if c.secret != "" || c.secretSource == nil || c.secretAsked {
+		return c.secret, nil
+	}
+	c.secretAsked = true
+	secret, err := c.secretSource()
+	if err != nil {
+		return "", err
+	}
+	c.secret = secret
+	return secret, nil
+}
+
+// Refresh the access token using the refresh token. The new tokens are
+// not saved, see refreshed.
+func (c *clearpass) refreshToken(ctx context.Context) error {
+	if c.refresh == "" || c.clientID == "" {
+		return ErrCannotRefresh
+	}
+	req := authRequest{
+		"grant_type":    "refresh_token",
+		"client_id":     c.clientID,
+		"refresh_token": c.refresh,
+	}
+	secret, err := c.clientSecret("")
+	if err != nil {
+		return err
+	}
+	if secret != "" {
+		req["client_secret"] = secret
+	}
+	_, _, err = c.auth(ctx, c.address, req)
+	return err
+}
+
+// refreshed runs the OnRefresh callback, to save the new tokens. Must be
+// called without holding the mutex, the callback may take a while.
+func (c *clearpass) refreshed(token, refresh string) {
+	if c.onRefresh == nil {
+		return
+	}
+	c.saving.Lock()
+	defer c.saving.Unlock()
+	c.onRefresh(token, refresh)
+}
+
+// Login into clearpass with the provided credentials, return token.
+func (c *clearpass) Login(ctx context.Context, address, clientID, secret, username, pass string) (string, string, error) {
+	req := authRequest{
+		"grant_type": "client_credentials",
+		"client_id":  clientID,
+	}
+	if secret != "" {
+		req["client_secret"] = secret
+	}
+	if username != "" && pass != "" {
+		req["grant_type"] = "password"
+		req["username"] = username
+		req["password"] = pass
+	}
+	return c.auth(ctx, address, req)
+}
+
+// Validate the token is still useful
+func (c *clearpass) Validate(ctx context.Context, address, clientID, secret, token, refresh string) (string, string, error) {
+	// If there is a refresh token, try to refresh auth. Confidential
+	// clients need their secret, from the secret source if not given.
+	if refresh != "" {
+		req := authRequest{
+			"grant_type":    "refresh_token",
+			"client_id":     clientID,
+			"refresh_token": refresh,
+		}
+		secret, err := c.clientSecret(secret)
+		if err == nil {
+			if secret != "" {
+				req["client_secret"] = secret
+			}
+			var t, r string
+			if t, r, err = c.auth(ctx, address, req); err == nil {
+				return t, r, err
+			}
+		}
+		c.tracer.printf("Token refresh failed: %s", err)
+	}
+	// No refresh or it didn't succeed, just check the
+	// current token is still valid.
+	baseURL := apiURL(address)
+	fullURL := baseURL + "/api-client/" + url.PathEscape(clientID)
+	var rep RawReply
+	if err := c.retry.rest(ctx, c.client, GET, fullURL, token, nil, nil, &rep); err != nil {
+		return "", "", err
+	}
+	c.address, c.apiURL, c.webURL = address, baseURL, webURL(address)
+	// Keep the expiration time and refresh token loaded with the token,
+	// to refresh it in time
+	if token != c.token {
+		c.refresh, c.expires = refresh, time.Time{}
+	} else if refresh != "" {
+		c.refresh = refresh
+	}
+	c.clientID, c.token = clientID, token
+	return c.token, c.refresh, nil
+}
+
+// Logout revokes the access and refresh tokens, and forgets them
+func (c *clearpass) Logout(ctx context.Context, address string) error {
+	fullURL := apiURL(address) + "/oauth/revoke"
+	tokens := []struct{ hint, token string }{
+		// Revoke the refresh token first, the access token is needed to authorize
+		{"refresh_token", c.refresh},
+		{"access_token", c.token},
+	}
+	// Confidential clients must authenticate to revoke their tokens
+	secret, err := c.clientSecret("")
+	if err != nil {
+		c.tracer.printf("Client secret not available to revoke the tokens: %s", err)
+	}
+	var result error
+	for _, t := range tokens {
+		if t.token == "" {
+			continue
+		}
+		req := authRequest{
+			"token":           t.token,
+			"token_type_hint": t.hint,
+			"client_id":       c.clientID,
+		}
+		if secret != "" {
+			req["client_secret"] = secret
+		}
+		var rep RawReply
+		err := c.retry.rest(ctx, c.client, POST, fullURL, c.token, nil, req, &rep)
+		if restErr, ok := err.(RestError); ok && (restErr.StatusCode == 404 || restErr.StatusCode == 405) {
+			result = ErrRevokeUnsupported
+			break
+		}
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+	c.token, c.refresh, c.expires = "", "", time.Time{}
+	return result
+}
+
+// WebLogin into clearpass with the provided credentials, return cookies.
+func (c *clearpass) WebLogin(ctx context.Context, address, username, pass string) ([]*http.Cookie, error) {
+	baseURL := webURL(address)
+	cookieURL, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	// Reset the cookie jar
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		log.Print("Error creating cookieJar, will not be able to use web login: ", err)
+		jar = nil
+	}
+	c.client.Jar = jar
+	// Get the first cookie
+	fullURL := baseURL + "/tipsLogin.action"
+	req, err := http.NewRequest(string(GET), fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	detail, _ := rawRequest(ctx, c.client, req, nil, false)
+	if detail.Err != nil {
+		return nil, err
+	}
+	// Get the DWR cookie
+	fullURL = baseURL + "/dwr/call/plaincall/__System.generateId.dwr"
+	dwrBody := "callCount=1\nc0-scriptName=__System\nc0-methodName=generateId\nc0-id=0\nbatchId=0\ninstanceId=0\npage=%2Ftips%2FtipsLogin.action\nscriptSessionId=\n"
+	req, err = http.NewRequest(string(POST), fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	detail, _ = rawRequest(ctx, c.client, req, []byte(dwrBody), false)
+	if detail.Err != nil {
+		return nil, detail
+	}
+	if detail.StatusCode != 200 {
+		detail.Err = errors.New("Failed to get dwr cookie with status != 200")
+		return nil, detail
+	}
+	// Parse the response and add the DWR cookie to the jar
+	dwrCookie := ""
+	parts := strings.SplitN(string(detail.Reply), "dwr.engine.remote.handleCallback(", 2)
+	if len(parts) > 1 {
+		parts = strings.SplitN(parts[1], "\"", 7)
+		if len(parts) > 5 {
+			cookies := c.client.Jar.Cookies(cookieURL)
+			dwrCookie = parts[5]
+			cookies = append(cookies, &http.Cookie{Name: dwrSessionCookie, Value: dwrCookie})
+			jar.SetCookies(cookieURL, cookies)
+		}
+	}
+	// Send the second pointless xhr request
+	fullURL = baseURL + "/dwr/call/plaincall/beforeLogin.getPublisherUrl.dwr"
+	dwrBody = "callCount=1\nnextReverseAjaxIndex=0\nc0-scriptName=beforeLogin\nc0-methodName=getPublisherUrl\nc0-id=0\nbatchId=1\ninstanceId=0\npage=%2Ftips%2FtipsLogin.action\nscriptSessionId=" + dwrCookie + "\n"
+	req, err = http.NewRequest(string(POST), fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	detail, _ = rawRequest(ctx, c.client, req, []byte(dwrBody), false)
+	if detail.Err != nil {
+		return nil, detail
+	}
+	if detail.StatusCode != 200 {
+		detail.Err = errors.New("Failed to confirm dwr cookie with status != 200")
+		return nil, detail
+	}
+	// Post the login data
+	fullURL = baseURL + "/tipsLoginSubmit.action"
+	data := make(url.Values)
+	data.Add("F_password", "0")
+	data.Add("username", username)
+	data.Add("password", pass)
+	req, err = http.NewRequest(string(POST), fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	detail, _ = rawRequest(ctx, c.client, req, []byte(data.Encode()), false)
+	if detail.Err != nil {
+		return nil, detail
+	}
+	// Finally, validate
+	cookies, err := c.WebValidate(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	c.webURL, c.apiURL = baseURL, apiURL(address)
+	return cookies, nil
+}
+
+// WebValidate checks the cookie is still useful
+func (c *clearpass) WebValidate(ctx context.Context, address string) ([]*http.Cookie, error) {
+	baseURL := webURL(address)
+	fullURL := baseURL + "/tipsContent.action"
+	req, err := http.NewRequest(string(GET), fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Referer", baseURL+"/tipsLogin.action")
+	req.Header.Add("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	req.Header.Add("Accept-Encoding", "gzip")
+	req.Header.Add("Accept-Encoding", "deflate")
+	req.Header.Add("Accept-Encoding", "br")
+	req.Header.Add("Upgrade-Insecure-Requests", "1")
+	detail, _ := rawRequest(ctx, c.client, req, nil, false)
+	if detail.Err != nil {
+		return nil, detail
+	}
+	cookies := c.cookies(baseURL)
+	if detail.StatusCode != 200 || cookies == nil || len(cookies) < 2 {
+		detail.Err = ErrNotLoggedIn
+		return nil, detail
+	}
+	return cookies, nil
+}
+
+// WebLogout from clearpass.
+// TODO: Check out. Does not seem to work.
+func (c *clearpass) WebLogout(ctx context.Context, address string) error {
+	baseURL := webURL(address)
+	// Find dwr session cookie
+	cookies := c.cookies(baseURL)
+	if cookies == nil {
+		return errors.New("Could not retrieve cookies")
+	}
+	dwrCookie := ""
+	for _, cookie := range cookies {
+		if cookie.Name == dwrSessionCookie {
+			dwrCookie = cookie.Value
+			break
+		}
+	}
+	if cookies == nil {
+		return errors.New("Could not retrieve DWR session cookie")
+	}
+	// Call XHR to close the session
+	fullURL := baseURL + "/dwr/call/plaincall/login.destroySession.dwr"
+	dwrBody := "callCount=1\nnextReverseAjaxIndex=0\nc0-scriptName=login\nc0-methodName=destroySession\nc0-id=0\nbatchId=1\ninstanceId=0\npage=%2Ftips%2FtipsContent.action\nscriptSessionId=" + dwrCookie + "\n"
+	req, err := http.NewRequest(string(POST), fullURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	detail, _ := rawRequest(ctx, c.client, req, []byte(dwrBody), false)
+	if detail.Err != nil {
+		return detail
+	}
+	if detail.StatusCode != 200 {
+		detail.Err = errors.New("Failed to close session with statuscode != 200")
+		return detail
+	}
+	// Call to checkStatus to make the logout effective
+	fullURL = baseURL + "/tipsLoginCheck.action"
+	req, err = http.NewRequest(string(GET), fullURL, nil)
+	if err != nil {
+		return err
+	}
+	detail, _ = rawRequest(ctx, c.client, req, nil, false)
+	if detail.Err != nil {
+		return detail
+	}
+	if detail.StatusCode != 302 {
+		detail.Err = errors.New("Did not get a redirect from tipsLoginCheck")
+		return detail
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateKeepsExpiration(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"client_id":"cpcli"}`)
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "https://")
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name    string
+		token   string
+		refresh string
+		expires time.Time
+	}{
+		{"same token", "token", "refresh", expires},
+		{"other token", "other", "", time.Time{}},
+	}
+	for _, test := range tests {
+		c := New(address, "cpcli", "token", "refresh", expires, nil, true)
+		token, refresh, err := c.Validate(context.Background(), address, "cpcli", "", test.token, "")
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if token != test.token || refresh != test.refresh {
+			t.Errorf("%s: got tokens %q, %q, want %q, %q", test.name, token, refresh, test.token, test.refresh)
+		}
+		if got := c.Expires(); !got.Equal(test.expires) {
+			t.Errorf("%s: got expiration %s, want %s", test.name, got, test.expires)
+		}
+	}
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error // nil, ErrRevokeUnsupported, or any other error
+	}{
+		{200, nil},
+		{404, ErrRevokeUnsupported},
+		{405, ErrRevokeUnsupported},
+		{500, Error("server error")},
+	}
+	for _, test := range tests {
+		var revoked []string
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["client_secret"] != "s3cret" {
+				w.WriteHeader(401)
+				return
+			}
+			revoked = append(revoked, req["token_type_hint"])
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(test.status)
+			fmt.Fprint(w, `{}`)
+		}))
+		address := strings.TrimPrefix(server.URL, "https://")
+		c := New(address, "cpcli", "token", "refresh", time.Now().Add(time.Hour), nil, true)
+		c.SetRetry(RetryPolicy{MaxAttempts: 1})
+		c.SecretSource(func() (string, error) { return "s3cret", nil })
+		err := c.Logout(context.Background(), address)
+		server.Close()
+		switch {
+		case test.want == nil && err != nil:
+			t.Errorf("status %d: got error %s", test.status, err)
+		case test.want == ErrRevokeUnsupported && err != ErrRevokeUnsupported:
+			t.Errorf("status %d: got error %v, want %s", test.status, err, ErrRevokeUnsupported)
+		case test.want != nil && err == nil:
+			t.Errorf("status %d: got no error", test.status)
+		}
+		if len(revoked) == 0 || revoked[0] != "refresh_token" {
+			t.Errorf("status %d: revoked %v, want the refresh token first", test.status, revoked)
+		}
+		if c.Token() != "" || c.(*clearpass).refresh != "" || !c.Expires().IsZero() {
+			t.Errorf("status %d: credentials not cleared", test.status)
+		}
+	}
+}
+
+func TestRefreshAsksSecret(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		if req["grant_type"] != "refresh_token" || req["client_secret"] != "s3cret" {
+			w.WriteHeader(400)
+			fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"new","refresh_token":"refresh2","expires_in":3600}`)
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "https://")
+	// Tokens cached by a previous run, about to expire
+	c := New(address, "cpcli", "old", "refresh", time.Now(), nil, true)
+	c.SetRetry(RetryPolicy{MaxAttempts: 1})
+	asked := 0
+	c.SecretSource(func() (string, error) {
+		asked++
+		return "s3cret", nil
+	})
+	var saved string
+	c.OnRefresh(func(token, refresh string) {
+		// The callback runs without the lock, it may use the client
+		saved = c.Token()
+	})
+	token, err := c.(*clearpass).validToken(context.Background())
+	if err != nil || token != "new" || saved != "new" {
+		t.Errorf("got token %q, saved %q, %v, want the new token saved", token, saved, err)
+	}
+	token, refresh, err := c.Validate(context.Background(), address, "cpcli", "", token, "refresh2")
+	if err != nil || token != "new" || refresh != "refresh2" {
+		t.Errorf("Validate got %q, %q, %v, want the token refreshed", token, refresh, err)
+	}
+	if asked != 1 {
+		t.Errorf("secret asked %d times, want once", asked)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/net/publicsuffix"
 )
@@ -20,6 +22,15 @@ const dwrSessionCookie = "DWRSESSIONID"
 // ErrPageTooSmall when paginated commands are givel a page size too small (<=0)
 const ErrPageTooSmall = Error("Page size is too small")
 
+// ErrCannotRefresh when there is no refresh token or client ID to renew the access token
+const ErrCannotRefresh = Error("No refresh token or client ID available to renew the access token")
+
+// ErrRevokeUnsupported when the server does not support token revocation
+const ErrRevokeUnsupported = Error("Token revocation is not supported by the server")
+
+// refreshMargin is how long before expiration the access token is renewed
+const refreshMargin = 30 * time.Second
+
 // Clearpass server interface
 type Clearpass interface {
 	// Login into CPPM. Returns access and refresh tokens, or error.
@@ -38,8 +49,24 @@ type Clearpass interface {
 	//   If a refresh token is provided, attempt to refresh the
 	//   authentication token. Otherwise, just check it is valid.
 	Validate(ctx context.Context, address, clientID, secret, token, refresh string) (string, string, error)
+	// Logout revokes the access and refresh tokens in the server, if supported,
+	// and forgets them. Tokens are forgotten even if revocation fails.
+	Logout(ctx context.Context, address string) error
 	// Token obtained after authentication / validation
 	Token() string
+	// Expires returns the expiration time of the token. Zero if unknown.
+	Expires() time.Time
+	// SetRetry sets the policy to retry requests on transient failures.
+	SetRetry(policy RetryPolicy)
+	// SetTrace enables the tracing of HTTP requests.
+	SetTrace(trace Trace)
+	// OnRefresh registers a callback to be run when the token is
+	// automatically refreshed, so the new tokens can be saved.
+	OnRefresh(callback func(token, refresh string))
+	// SecretSource registers a callback to get the client secret, when
+	// the token is refreshed and no secret was given to Login or Validate
+	// (e.g. tokens cached by a previous run). Called at most once.
+	SecretSource(source func() (string, error))
 	// WebLogin into clearpass with the provided credentials, return token.
 	WebLogin(ctx context.Context, address, username, pass string) ([]*http.Cookie, error)
 	// WebLogout from clearpass.
@@ -50,6 +77,8 @@ type Clearpass interface {
 	Cookies() []*http.Cookie
 	// Request made to the CPPM.
 	Request(method Method, path string, params Params, request interface{}) *Reply
+	// Resolve returns the URL and query params that Request would send.
+	Resolve(path string, params Params) (string, Params, error)
 	// Export some resource from ClearPass, return the exported stream.
 	Export(ctx context.Context, resource, pass string) (string, io.ReadCloser, error)
 	// Import some resource to ClearPass.
@@ -58,12 +87,26 @@ type Clearpass interface {
 
 // Clearpass model
 type clearpass struct {
-	unsafe  bool
-	apiURL  string
-	webURL  string
-	token   string
-	refresh string
-	client  *http.Client
+	unsafe    bool
+	address   string
+	apiURL    string
+	webURL    string
+	clientID  string
+	secret    string
+	token     string
+	refresh   string
+	expires   time.Time
+	onRefresh func(token, refresh string)
+	client    *http.Client
+	tracer    *tracer
+	retry     RetryPolicy
+	// Client secret, loaded only if needed to refresh the token
+	secretSource func() (string, error)
+	secretAsked  bool
+	// Protects the tokens when pages are fetched concurrently
+	mutex sync.Mutex
+	// Serializes the OnRefresh callbacks, run without the mutex
+	saving sync.Mutex
 }
 
 // apiURL returns the URL of the API
@@ -76,8 +119,9 @@ func webURL(address string) string {
 	return fmt.Sprintf("https://%s/tips", url.PathEscape(address))
 }
 
-// New creates a Clearpass object with cached IP and token
-func New(address, token, refresh string, cookies []*http.Cookie, skipVerify bool) Clearpass {
+// New creates a Clearpass object with cached IP and token.
+// 'expires' is the expiration time of the token, zero if unknown.
+func New(address, clientID, token, refresh string, expires time.Time, cookies []*http.Cookie, skipVerify bool) Clearpass {
 	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
 	if err != nil {
 		log.Print("Error creating cookieJar, will not be able to use web login: ", err)
@@ -91,29 +135,110 @@ func New(address, token, refresh string, cookies []*http.Cookie, skipVerify bool
 			jar.SetCookies(q, cookies)
 		}
 	}
-	client := &http.Client{
-		Transport: &http.Transport{
+	tracer := &tracer{
+		next: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: skipVerify},
 		},
+		unsafe: skipVerify,
+	}
+	client := &http.Client{
+		Transport: tracer,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 		Jar: jar,
 	}
 	return &clearpass{
-		apiURL:  apiURL(address),
-		webURL:  queryURL,
-		token:   token,
-		refresh: refresh,
-		client:  client,
+		address:  address,
+		apiURL:   apiURL(address),
+		webURL:   queryURL,
+		clientID: clientID,
+		token:    token,
+		refresh:  refresh,
+		expires:  expires,
+		client:   client,
+		tracer:   tracer,
+		retry:    DefaultRetryPolicy,
 	}
 }
 
 // Token implements Clearpass interface
 func (c *clearpass) Token() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return c.token
 }
 
+// Expires implements Clearpass interface
+func (c *clearpass) Expires() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.expires
+}
+
+// SetRetry implements Clearpass interface
+func (c *clearpass) SetRetry(policy RetryPolicy) {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	c.retry = policy
+}
+
+// SetTrace implements Clearpass interface
+func (c *clearpass) SetTrace(trace Trace) {
+	c.tracer.Trace = trace
+}
+
+// OnRefresh implements Clearpass interface
+func (c *clearpass) OnRefresh(callback func(token, refresh string)) {
+	c.onRefresh = callback
+}
+
+// SecretSource implements Clearpass interface
+func (c *clearpass) SecretSource(source func() (string, error)) {
+	c.secretSource = source
+}
+
+// validToken implements tokenSource. Refreshes the token
+// if it is about to expire.
+func (c *clearpass) validToken(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	refreshed := false
+	if !c.expires.IsZero() && time.Now().Add(refreshMargin).After(c.expires) {
+		// If refresh fails, keep using the current token and
+		// let the server decide whether it is still valid.
+		if err := c.refreshToken(ctx); err != nil {
+			c.tracer.printf("Token refresh failed: %s", err)
+		} else {
+			refreshed = true
+		}
+	}
+	token, refresh := c.token, c.refresh
+	c.mutex.Unlock()
+	if refreshed {
+		c.refreshed(token, refresh)
+	}
+	return token, nil
+}
+
+// renewToken implements tokenSource
+func (c *clearpass) renewToken(ctx context.Context, token string) (string, error) {
+	c.mutex.Lock()
+	// Some other request may have renewed it already
+	if c.token != token {
+		defer c.mutex.Unlock()
+		return c.token, nil
+	}
+	err := c.refreshToken(ctx)
+	token, refresh := c.token, c.refresh
+	c.mutex.Unlock()
+	if err != nil {
+		return "", err
+	}
+	c.refreshed(token, refresh)
+	return token, nil
+}
+
 // Cookie implements Clearpass interface
 func (c *clearpass) Cookies() []*http.Cookie {
 	return c.cookies(c.webURL)
@@ -136,26 +261,42 @@ func (c *clearpass) cookies(cpURL string) []*http.Cookie {
 
 // Follow a stream of results from an endpoint.
 func (c *clearpass) Request(method Method, path string, params Params, request interface{}) *Reply {
-	if c.apiURL == "" || c.token == "" {
+	c.mutex.Lock()
+	token := c.token
+	c.mutex.Unlock()
+	if c.apiURL == "" || token == "" {
 		return NewReply(nil, ErrNotLoggedIn)
 	}
+	address, defaults, err := c.Resolve(path, params)
+	if err != nil {
+		return NewReply(nil, err)
+	}
+	reply := Request(c.client, method, address, token, defaults, request)
+	reply.source, reply.retry = c, c.retry
+	return reply
+}
+
+// Resolve implements Clearpass interface
+func (c *clearpass) Resolve(path string, params Params) (string, Params, error) {
 	// Clone params, if any
 	var defaults Params
 	if params != nil && len(params) > 0 {
-		defaults := make(Params)
+		defaults = make(Params)
 		for k, v := range params {
 			defaults[k] = v
 		}
-		if _, ok := defaults["limit"]; ok {
+		// Counting is expensive, skip it when paging unless asked for
+		_, limit := defaults["limit"]
+		if _, count := defaults["calculate_count"]; limit && !count {
 			defaults["calculate_count"] = "false"
 		}
 		if filter, ok := defaults["filter"]; ok {
 			norm, err := normalize(filter, path)
 			if err != nil {
-				return NewReply(nil, err)
+				return "", nil, err
 			}
 			defaults["filter"] = norm
 		}
 	}
-	return Request(c.client, method, c.apiURL+"/"+path, c.token, defaults, request)
+	return c.apiURL + "/" + path, defaults, nil
 }
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
@@ -38,10 +39,14 @@ type RestError struct {
 	StatusCode  int
 	ReplyHeader http.Header
 	Reply       []byte
+	Attempts    []Attempt // Failed attempts, if the request was retried
 }
 
-// Error implements Error interface
+// Error implements Error interface. Secrets are masked, unless ShowSecrets.
 func (e RestError) Error() string {
+	if !ShowSecrets {
+		e = e.redacted()
+	}
 	detail := make([]string, 0, 16)
 	if e.Err != nil {
 		detail = append(detail, e.Err.Error())
@@ -66,5 +71,73 @@ func (e RestError) Error() string {
 	if e.Reply != nil {
 		detail = append(detail, fmt.Sprint("Reply: ", string(e.Reply)))
 	}
+	for i, attempt := range e.Attempts {
+		text := fmt.Sprintf("Attempt %d: status %d", i+1, attempt.StatusCode)
+		if attempt.Err != nil {
+			text = fmt.Sprintf("%s, %s", text, attempt.Err)
+		}
+		if attempt.Delay > 0 {
+			text = fmt.Sprintf("%s, retried after %s", text, attempt.Delay)
+		}
+		detail = append(detail, text)
+	}
 	return strings.Join(detail, "\n  ")
 }
+
+// restErrorJSON is the JSON rendering of a RestError
+type restErrorJSON struct {
+	Error      string          `json:"error,omitempty"`
+	Method     Method          `json:"method,omitempty"`
+	URL        string          `json:"url,omitempty"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	Reply      json.RawMessage `json:"reply,omitempty"`
+	Attempts   []attemptJSON   `json:"attempts,omitempty"`
+}
+
+// attemptJSON is the JSON rendering of an Attempt
+type attemptJSON struct {
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Delay      string `json:"delay,omitempty"`
+}
+
+// rawJSON embeds the data as is if it is valid JSON, or as a string otherwise
+func rawJSON(data []byte) json.RawMessage {
+	if len(data) == 0 {
+		return nil
+	}
+	if json.Valid(data) {
+		return data
+	}
+	text, _ := json.Marshal(string(data))
+	return text
+}
+
+// MarshalJSON renders the error as a JSON object. Secrets are masked, unless ShowSecrets.
+func (e RestError) MarshalJSON() ([]byte, error) {
+	if !ShowSecrets {
+		e = e.redacted()
+	}
+	result := restErrorJSON{
+		Method:     e.Method,
+		URL:        e.URL,
+		StatusCode: e.StatusCode,
+		Body:       rawJSON(e.Body),
+		Reply:      rawJSON(e.Reply),
+	}
+	if e.Err != nil {
+		result.Error = e.Err.Error()
+	}
+	for _, attempt := range e.Attempts {
+		current := attemptJSON{StatusCode: attempt.StatusCode}
+		if attempt.Err != nil {
+			current.Error = attempt.Err.Error()
+		}
+		if attempt.Delay > 0 {
+			current.Delay = attempt.Delay.String()
+		}
+		result.Attempts = append(result.Attempts, current)
+	}
+	return json.Marshal(result)
+}
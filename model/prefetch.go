@@ -0,0 +1,117 @@
+package model
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// page fetched in the background
+type page struct {
+	reply RawReply
+	err   error
+}
+
+// SetPrefetch enables fetching up to 'depth' pages ahead in the background,
+// with up to 'workers' concurrent requests. Prefetching only starts when the
+// server reports the total count of items (calculate_count=true).
+func (r *Reply) SetPrefetch(depth, workers int) *Reply {
+	if workers <= 0 {
+		workers = 1
+	}
+	r.prefetch, r.workers = depth, workers
+	return r
+}
+
+// startPrefetch computes the URLs of the remaining pages from the next link,
+// and starts fetching them in the background. Pages are delivered in order
+// through r.pages.
+func (r *Reply) startPrefetch(ctx context.Context, pageSize int) {
+	if r.prefetch <= 0 || r.count < 0 || r.nextURL == "" {
+		return
+	}
+	next, err := url.Parse(r.nextURL)
+	if err != nil {
+		return
+	}
+	query := next.Query()
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil {
+		return
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = pageSize
+	}
+	if limit <= 0 {
+		return
+	}
+	last := r.count
+	if r.max > 0 {
+		// Items still needed after the current page
+		needed := r.max - r.yielded - pageSize
+		if needed < last-offset {
+			last = offset + needed
+		}
+	}
+	urls := make([]string, 0, (last-offset)/limit+1)
+	for ; offset < last; offset += limit {
+		query.Set("offset", strconv.Itoa(offset))
+		next.RawQuery = query.Encode()
+		urls = append(urls, next.String())
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	pages := make(chan chan page, r.prefetch)
+	workers := make(chan struct{}, r.workers)
+	go func() {
+		defer close(pages)
+		for _, pageURL := range urls {
+			result := make(chan page, 1)
+			select {
+			case pages <- result:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case workers <- struct{}{}:
+			case <-ctx.Done():
+				result <- page{err: ctx.Err()}
+				return
+			}
+			go func(pageURL string) {
+				defer func() { <-workers }()
+				reply, err := r.fetch(ctx, pageURL)
+				result <- page{reply: reply, err: err}
+			}(pageURL)
+		}
+	}()
+	r.pages, r.nextURL = pages, ""
+}
+
+// prefetched returns the next page fetched in the background.
+// Returns nil when there are no more pages.
+func (r *Reply) prefetched(ctx context.Context) (RawReply, error) {
+	select {
+	case result, ok := <-r.pages:
+		if !ok {
+			r.stopPrefetch()
+			return nil, nil
+		}
+		select {
+		case p := <-result:
+			return p.reply, p.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// stopPrefetch cancels the pages being fetched in the background, if any
+func (r *Reply) stopPrefetch() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.pages = nil
+}
@@ -0,0 +1,133 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPrefetch(t *testing.T) {
+	var requests int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		w.Header().Set("Content-Type", "application/json")
+		links := "{}"
+		if offset+2 < 10 {
+			links = fmt.Sprintf(`{"next":{"href":"%s/api/item?offset=%d&limit=2"}}`, server.URL, offset+2)
+		}
+		fmt.Fprintf(w, `{"_embedded":{"items":[{"id":%d},{"id":%d}]},"count":10,"_links":%s}`, offset, offset+1, links)
+	}))
+	defer server.Close()
+	tests := []struct {
+		name     string
+		max      int
+		items    int
+		requests int32
+	}{
+		{"all pages", 0, 10, 5},
+		{"max", 5, 5, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			reply := Request(server.Client(), GET, server.URL+"/api/item", "token", map[string]string{"limit": "2", "calculate_count": "true"}, nil)
+			reply.SetMax(test.max).SetPrefetch(4, 2)
+			items := 0
+			for reply.Next(context.Background()) {
+				var item struct{ ID int }
+				if err := json.Unmarshal(reply.Get(), &item); err != nil {
+					t.Fatal(err)
+				}
+				if item.ID != items {
+					t.Errorf("item %d has id %d, pages out of order", items, item.ID)
+				}
+				items++
+			}
+			if reply.Error() != nil {
+				t.Fatal(reply.Error())
+			}
+			if items != test.items {
+				t.Errorf("got %d items, want %d", items, test.items)
+			}
+			if got := atomic.LoadInt32(&requests); got != test.requests {
+				t.Errorf("got %d requests, want %d", got, test.requests)
+			}
+		})
+	}
+}
+
+// pagedServer serves 10 items in pages. Pages after the second one
+// block until the client cancels the request, and report it.
+func pagedServer(cancelled chan<- int) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		if offset >= 4 {
+			<-r.Context().Done()
+			cancelled <- offset
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"_embedded":{"items":[{"id":%d},{"id":%d}]},"count":10,`, offset, offset+1)
+		fmt.Fprintf(w, `"_links":{"self":{"href":"%s/api/item?offset=%d&limit=2"},"next":{"href":"%s/api/item?offset=%d&limit=2"}}}`, server.URL, offset, server.URL, offset+2)
+	}))
+	return server
+}
+
+func TestMaxStopsPrefetch(t *testing.T) {
+	server := pagedServer(make(chan int, 8))
+	defer server.Close()
+	reply := Request(server.Client(), GET, server.URL+"/api/item", "token", map[string]string{"limit": "2", "calculate_count": "true"}, nil)
+	reply.SetMax(3).SetPrefetch(4, 2)
+	items := 0
+	for reply.Next(context.Background()) {
+		items++
+		if items == 3 && reply.pages != nil {
+			t.Error("prefetch still running after the last item")
+		}
+	}
+	if items != 3 || reply.Error() != nil {
+		t.Errorf("got %d items, %v, want 3 items", items, reply.Error())
+	}
+}
+
+func TestCloseStopsPrefetch(t *testing.T) {
+	cancelled := make(chan int, 8)
+	server := pagedServer(cancelled)
+	defer server.Close()
+	reply := Request(server.Client(), GET, server.URL+"/api/item", "token", map[string]string{"limit": "2", "calculate_count": "true"}, nil)
+	reply.SetPrefetch(4, 2)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if !reply.Next(ctx) {
+			t.Fatalf("item %d missing: %v", i, reply.Error())
+		}
+	}
+	pages := reply.pages
+	reply.Close()
+	if reply.Next(ctx) {
+		t.Error("Next after Close returned an item")
+	}
+	// The prefetching goroutine ends, and the pending requests are cancelled
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-pages:
+		case <-timeout:
+			t.Fatal("prefetch not stopped")
+		}
+	}
+	select {
+	case <-cancelled:
+	case <-timeout:
+		t.Error("pending pages not cancelled")
+	}
+}
@@ -0,0 +1,242 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ShowSecrets disables the masking of secrets in errors and traces.
+// Only meant for debugging.
+var ShowSecrets = false
+
+// Redacted replaces the secrets in errors and traces
+const Redacted = "[REDACTED]"
+
+// sensitiveHeaders are masked in errors and traces
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+}
+
+// sensitiveNames are the attributes in bodies and queries that are masked,
+// as the whole name or its last words (e.g. "client_secret", "access_token",
+// but not "token_type").
+var sensitiveNames = []string{
+	"secret",
+	"secrets",
+	"password",
+	"passwd",
+	"passphrase",
+	"token",
+	"cookie",
+	"community",
+	"community_string",
+	"private_key",
+}
+
+// formAttribute matches a key=value pair in forms, queries and DWR bodies
+var formAttribute = regexp.MustCompile(`(^|[&\n])([^=&\n]+)=([^&\n]*)`)
+
+// Sensitive checks if the attribute name looks like a secret
+func Sensitive(name string) bool {
+	name = snakeCase(name)
+	// Session cookies, e.g. JSESSIONID
+	if strings.HasSuffix(name, "sessionid") {
+		return true
+	}
+	for _, word := range sensitiveNames {
+		if name == word || strings.HasSuffix(name, "_"+word) {
+			return true
+		}
+	}
+	return false
+}
+
+// snakeCase lowercases the name, with its words separated by
+// underscores, e.g. "encryptionPassword" => "encryption_password"
+func snakeCase(name string) string {
+	var result strings.Builder
+	previous := ' '
+	for _, r := range name {
+		switch {
+		case r == '-' || r == '.' || r == ' ':
+			r = '_'
+		case unicode.IsUpper(r) && (unicode.IsLower(previous) || unicode.IsDigit(previous)):
+			result.WriteByte('_')
+		}
+		result.WriteRune(unicode.ToLower(r))
+		previous = r
+	}
+	return result.String()
+}
+
+// Mask returns Redacted instead of the secret, unless ShowSecrets
+func Mask(secret string) string {
+	if ShowSecrets || secret == "" {
+		return secret
+	}
+	return Redacted
+}
+
+// RedactHeader returns a copy of the header with the secrets masked.
+// The scheme of Authorization headers (e.g. "Bearer") is kept.
+func RedactHeader(header http.Header) http.Header {
+	if ShowSecrets || header == nil {
+		return header
+	}
+	result := make(http.Header, len(header))
+	for name, values := range header {
+		result[name] = values
+	}
+	for _, name := range sensitiveHeaders {
+		values := header[http.CanonicalHeaderKey(name)]
+		if values == nil {
+			continue
+		}
+		masked := make([]string, 0, len(values))
+		for _, value := range values {
+			if scheme := strings.Fields(value); strings.HasSuffix(name, "Authorization") && len(scheme) > 1 {
+				masked = append(masked, scheme[0]+" "+Redacted)
+			} else {
+				masked = append(masked, Redacted)
+			}
+		}
+		result[http.CanonicalHeaderKey(name)] = masked
+	}
+	return result
+}
+
+// RedactBody masks the values of secret attributes in JSON bodies,
+// forms or DWR requests. The layout of the body is kept.
+func RedactBody(body []byte) []byte {
+	if ShowSecrets || len(body) == 0 {
+		return body
+	}
+	if json.Valid(body) {
+		return redactJSON(body)
+	}
+	return replaceSubmatch(formAttribute, body, 2, 3, []byte(Redacted))
+}
+
+// RedactURL masks the values of secret query parameters
+func RedactURL(url string) string {
+	if ShowSecrets {
+		return url
+	}
+	parts := strings.SplitN(url, "?", 2)
+	if len(parts) < 2 {
+		return url
+	}
+	return parts[0] + "?" + string(RedactBody([]byte(parts[1])))
+}
+
+// RedactError masks the secret query parameters in the URL of
+// errors from the http client, e.g. timeouts.
+func RedactError(err error) error {
+	urlErr, ok := err.(*url.Error)
+	if ShowSecrets || !ok {
+		return err
+	}
+	masked := *urlErr
+	masked.URL = RedactURL(masked.URL)
+	return &masked
+}
+
+// redactJSON masks the values of secret attributes of a valid JSON
+// body, objects and arrays as a whole. The body is walked token by
+// token, and the values replaced in place.
+func redactJSON(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	result := make([]byte, 0, len(body))
+	last := 0
+	// Objects and arrays open, true for objects
+	objects := make([]bool, 0, 8)
+	// The next token is the name of an attribute
+	name := false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		if key, ok := token.(string); ok && name {
+			name = false
+			if !Sensitive(key) {
+				continue
+			}
+			start := valueStart(body, int(decoder.InputOffset()))
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err != nil {
+				return body
+			}
+			result = append(result, body[last:start]...)
+			result = append(result, `"`+Redacted+`"`...)
+			last = int(decoder.InputOffset())
+		} else if delim, ok := token.(json.Delim); ok && (delim == '{' || delim == '[') {
+			objects = append(objects, delim == '{')
+			name = delim == '{'
+			continue
+		} else if ok {
+			objects = objects[:len(objects)-1]
+		}
+		// After a value, a name follows inside objects
+		name = len(objects) > 0 && objects[len(objects)-1]
+	}
+	return append(result, body[last:]...)
+}
+
+// valueStart skips the colon and spaces after an attribute name
+func valueStart(data []byte, start int) int {
+	for start < len(data) && strings.IndexByte(": \t\r\n", data[start]) >= 0 {
+		start++
+	}
+	return start
+}
+
+// replaceSubmatch replaces the 'value' submatch with the mask,
+// when the 'key' submatch is sensitive.
+func replaceSubmatch(re *regexp.Regexp, data []byte, key, value int, mask []byte) []byte {
+	matches := re.FindAllSubmatchIndex(data, -1)
+	if matches == nil {
+		return data
+	}
+	result := make([]byte, 0, len(data))
+	last := 0
+	for _, match := range matches {
+		if !Sensitive(string(data[match[2*key]:match[2*key+1]])) {
+			continue
+		}
+		result = append(result, data[last:match[2*value]]...)
+		result = append(result, mask...)
+		last = match[2*value+1]
+	}
+	return append(result, data[last:]...)
+}
+
+// redacted returns a copy of the error with the secrets masked
+func (e RestError) redacted() RestError {
+	e.URL = RedactURL(e.URL)
+	e.Query = string(RedactBody([]byte(e.Query)))
+	e.Header = RedactHeader(e.Header)
+	e.Body = RedactBody(e.Body)
+	e.ReplyHeader = RedactHeader(e.ReplyHeader)
+	e.Reply = RedactBody(e.Reply)
+	e.Err = RedactError(e.Err)
+	if e.Attempts != nil {
+		attempts := make([]Attempt, 0, len(e.Attempts))
+		for _, attempt := range e.Attempts {
+			attempt.Err = RedactError(attempt.Err)
+			attempts = append(attempts, attempt)
+		}
+		e.Attempts = attempts
+	}
+	return e
+}
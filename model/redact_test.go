@@ -0,0 +1,142 @@
+package model
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{``, ``},
+		{`{"name":"a","password":"p4ss"}`, `{"name":"a","password":"[REDACTED]"}`},
+		{`{"client_secret" : "s\"x", "id": 1}`, `{"client_secret" : "[REDACTED]", "id": 1}`},
+		{`{"radius_secret":null,"pin":1234}`, `{"radius_secret":"[REDACTED]","pin":1234}`},
+		{`{"Access_Token":"abc"}`, `{"Access_Token":"[REDACTED]"}`},
+		{`{"attributes":{"snmp_community":"public"}}`, `{"attributes":{"snmp_community":"[REDACTED]"}}`},
+		{`{"note":"password"}`, `{"note":"password"}`},
+		{`[{"token":"a"},{"token":"b"}]`, `[{"token":"[REDACTED]"},{"token":"[REDACTED]"}]`},
+		{`{"password":{"old":"a","new":"b"},"name":"x"}`, `{"password":"[REDACTED]","name":"x"}`},
+		{`{"secrets" : ["a", {"b": "c"}], "id": 1}`, `{"secrets" : "[REDACTED]", "id": 1}`},
+		{`{"token":{"value":"}]\"{"},"id":1}`, `{"token":"[REDACTED]","id":1}`},
+		{`{"token":{"password":{"a":1}},"cookie":{}}`, `{"token":"[REDACTED]","cookie":"[REDACTED]"}`},
+		{`{"attributes":{"id":1}}`, `{"attributes":{"id":1}}`},
+		{`{"token_type":"Bearer","access_token_lifetime":3600,"access_token":"a"}`, `{"token_type":"Bearer","access_token_lifetime":3600,"access_token":"[REDACTED]"}`},
+		{`[1,{"a":["token",{"refresh_token":2}]},{"big":1e400}]`, `[1,{"a":["token",{"refresh_token":"[REDACTED]"}]},{"big":1e400}]`},
+		{"{\n  \"a\": [\n    1\n  ],\n  \"password\":\n    \"p\"\n}", "{\n  \"a\": [\n    1\n  ],\n  \"password\":\n    \"[REDACTED]\"\n}"},
+		{`grant_type=password&username=u&password=p&client_secret=s`, `grant_type=password&username=u&password=[REDACTED]&client_secret=[REDACTED]`},
+		{"callCount=1\nc0-param0=string:x\npassword=p\n", "callCount=1\nc0-param0=string:x\npassword=[REDACTED]\n"},
+		{`password=`, `password=[REDACTED]`},
+		{`not a form`, `not a form`},
+	}
+	for _, test := range tests {
+		if got := string(RedactBody([]byte(test.body))); got != test.want {
+			t.Errorf("RedactBody(%q) = %q, want %q", test.body, got, test.want)
+		}
+	}
+}
+
+func TestSensitive(t *testing.T) {
+	tests := map[string]bool{
+		"password":              true,
+		"client_secret":         true,
+		"Access_Token":          true,
+		"refresh_token":         true,
+		"encryptionPassword":    true,
+		"F_password":            true,
+		"community_string":      true,
+		"JSESSIONID":            true,
+		"token_type":            false,
+		"token_type_hint":       false,
+		"access_token_lifetime": false,
+		"password_policy":       false,
+		"web_session":           false,
+		"name":                  false,
+	}
+	for name, want := range tests {
+		if got := Sensitive(name); got != want {
+			t.Errorf("Sensitive(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://cppm/api/role", "https://cppm/api/role"},
+		{"https://cppm/api/x?token=abc&limit=1", "https://cppm/api/x?token=[REDACTED]&limit=1"},
+		{"https://cppm/api/x?filter=%7B%7D", "https://cppm/api/x?filter=%7B%7D"},
+	}
+	for _, test := range tests {
+		if got := RedactURL(test.url); got != test.want {
+			t.Errorf("RedactURL(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	urlErr := &url.Error{Op: "Get", URL: "https://cppm/api/x?token=abc&limit=1", Err: errors.New("timeout")}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{errors.New("token=abc"), "token=abc"},
+		{urlErr, `Get "https://cppm/api/x?token=[REDACTED]&limit=1": timeout`},
+	}
+	for _, test := range tests {
+		got := RedactError(test.err)
+		if (got == nil && test.want != "") || (got != nil && got.Error() != test.want) {
+			t.Errorf("RedactError(%v) = %v, want %s", test.err, got, test.want)
+		}
+	}
+	if urlErr.URL != "https://cppm/api/x?token=abc&limit=1" {
+		t.Error("RedactError modified the original error")
+	}
+	restErr := RestError{Err: urlErr, Attempts: []Attempt{{Err: urlErr}}}
+	if text := restErr.Error(); strings.Contains(text, "abc") {
+		t.Errorf("RestError.Error() = %q, token not masked", text)
+	}
+	if data, err := restErr.MarshalJSON(); err != nil || strings.Contains(string(data), "abc") {
+		t.Errorf("RestError.MarshalJSON() = %s, %v, token not masked", data, err)
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	header := http.Header{
+		"Authorization": {"Bearer abc"},
+		"Cookie":        {"PHPSESSID=1"},
+		"Accept":        {"application/json"},
+	}
+	want := http.Header{
+		"Authorization": {"Bearer [REDACTED]"},
+		"Cookie":        {"[REDACTED]"},
+		"Accept":        {"application/json"},
+	}
+	if got := RedactHeader(header); !reflect.DeepEqual(got, want) {
+		t.Errorf("RedactHeader() = %v, want %v", got, want)
+	}
+	if header.Get("Authorization") != "Bearer abc" {
+		t.Error("RedactHeader modified the original header")
+	}
+}
+
+func TestShowSecrets(t *testing.T) {
+	ShowSecrets = true
+	defer func() { ShowSecrets = false }()
+	body := `{"password":"p"}`
+	if got := string(RedactBody([]byte(body))); got != body {
+		t.Errorf("RedactBody with ShowSecrets = %q, want %q", got, body)
+	}
+	if got := Mask("p"); got != "p" {
+		t.Errorf("Mask with ShowSecrets = %q", got)
+	}
+}
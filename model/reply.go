@@ -1,9 +1,12 @@
 package model
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -25,10 +28,42 @@ type Reply struct {
 	err     error
 	method  Method
 	nextURL string
-	token   string
 	query   map[string]string
 	request interface{}
 	client  *http.Client
+	source  tokenSource
+	retry   RetryPolicy
+	count   int // Total count reported by the server, -1 if unknown
+	max     int // Max number of items to yield, 0 for no limit
+	yielded int
+	// The server returned a collection of items, not a single object
+	collection bool
+	// Background prefetching of pages, see prefetch.go
+	prefetch int
+	workers  int
+	pages    chan chan page
+	cancel   context.CancelFunc
+	closed   bool
+}
+
+// tokenSource provides the bearer token for the requests
+type tokenSource interface {
+	// validToken returns the current token, refreshed if about to expire.
+	validToken(ctx context.Context) (string, error)
+	// renewToken refreshes the token, if it is still the given one.
+	// Otherwise, it was already refreshed, and the new one is returned.
+	renewToken(ctx context.Context, token string) (string, error)
+}
+
+// staticToken is a tokenSource that can't be refreshed
+type staticToken string
+
+func (t staticToken) validToken(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+func (t staticToken) renewToken(ctx context.Context, token string) (string, error) {
+	return "", ErrCannotRefresh
 }
 
 // HalLink is a link inside a struct
@@ -54,6 +89,7 @@ type wrappedItems struct {
 type wrappedReply struct {
 	Embedded wrappedItems `json:"_embedded"`
 	Links    halLinks     `json:"_links"`
+	Count    *int         `json:"count"`
 }
 
 // Request runs a REST request and returns an 'iterable' Reply
@@ -61,13 +97,42 @@ func Request(client *http.Client, method Method, url, token string, query map[st
 	return &Reply{
 		method:  method,
 		nextURL: url,
-		token:   token,
 		query:   query,
 		request: request,
 		client:  client,
+		source:  staticToken(token),
+		count:   -1,
 	}
 }
 
+// SetRetry sets the retry policy for transient failures
+func (r *Reply) SetRetry(policy RetryPolicy) *Reply {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	r.retry = policy
+	return r
+}
+
+// SetMax limits the number of items yielded by the Reply. Once
+// reached, no more pages are requested. 0 means no limit.
+func (r *Reply) SetMax(max int) *Reply {
+	r.max = max
+	return r
+}
+
+// Count returns the total number of items reported by the server,
+// if the request asked for calculate_count. Valid after the first Next.
+func (r *Reply) Count() (int, bool) {
+	return r.count, r.count >= 0
+}
+
+// Collection reports if the server returned a collection of items,
+// rather than a single object. Valid after the first Next.
+func (r *Reply) Collection() bool {
+	return r.collection
+}
+
 // Get returns the current reply
 func (r *Reply) Get() RawReply {
 	return r.current[r.offset]
@@ -75,6 +140,29 @@ func (r *Reply) Get() RawReply {
 
 // Next asks for the next reply in the stream
 func (r *Reply) Next(ctx context.Context) bool {
+	if r.closed || (r.max > 0 && r.yielded >= r.max) {
+		return false
+	}
+	if !r.next(ctx) {
+		return false
+	}
+	r.yielded++
+	// No more pages will be needed, stop prefetching them
+	if r.max > 0 && r.yielded >= r.max {
+		r.stopPrefetch()
+	}
+	return true
+}
+
+// Close ends the iteration before the stream is exhausted, and
+// cancels the pages being fetched in the background, if any.
+func (r *Reply) Close() {
+	r.closed = true
+	r.stopPrefetch()
+}
+
+// next moves to the next reply, fetching more pages if needed
+func (r *Reply) next(ctx context.Context) bool {
 	// If there is an error, stop iterating
 	if r.err != nil {
 		return false
@@ -85,22 +173,42 @@ func (r *Reply) Next(ctx context.Context) bool {
 		return true
 	}
 	// Otherwise, keep asking for the next data
-	for r.nextURL != "" {
-		result := RawReply{}
-		if err := rest(ctx, r.client, r.method, r.nextURL, r.token, r.query, r.request, &result); err != nil {
-			r.err = err
+	for r.nextURL != "" || r.pages != nil {
+		var result RawReply
+		var err error
+		if r.pages != nil {
+			result, err = r.prefetched(ctx)
+		} else {
+			result, err = r.fetch(ctx, r.nextURL)
+		}
+		if err != nil {
+			r.fail(err)
 			return false
 		}
+		// If there was no content (e.g. DELETE), we are done
+		if len(result) == 0 {
+			r.nextURL = ""
+			continue
+		}
 		// If result is not wrapped, we are done
 		wReply := wrappedReply{}
 		if err := json.Unmarshal(result, &wReply); err != nil || wReply.Embedded.Items == nil {
 			r.current, r.offset, r.nextURL = []RawReply{result}, 0, ""
 			return true
 		}
-		// Update the results and the next URL.
-		r.current, r.offset, r.nextURL = wReply.Embedded.Items, 0, ""
-		if nextURL := wReply.Links.Next.Href; nextURL != wReply.Links.Self.Href {
-			r.method, r.nextURL, r.query, r.request = GET, nextURL, nil, nil
+		// Update the results, count and the next URL.
+		r.collection = true
+		if wReply.Count != nil {
+			r.count = *wReply.Count
+		}
+		r.current, r.offset = wReply.Embedded.Items, 0
+		// Prefetched pages already cover the next links
+		if r.pages == nil {
+			r.nextURL = ""
+			if nextURL := wReply.Links.Next.Href; nextURL != wReply.Links.Self.Href {
+				r.method, r.nextURL, r.query, r.request = GET, nextURL, nil, nil
+				r.startPrefetch(ctx, len(r.current))
+			}
 		}
 		// And leave - unless we got an empty response.
 		if len(r.current) > 0 {
@@ -111,6 +219,29 @@ func (r *Reply) Next(ctx context.Context) bool {
 	return false
 }
 
+// fetch the data at the given URL. If not authorized, renew the token and retry once.
+func (r *Reply) fetch(ctx context.Context, url string) (RawReply, error) {
+	token, err := r.source.validToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := RawReply{}
+	err = r.retry.rest(ctx, r.client, r.method, url, token, r.query, r.request, &result)
+	if restErr, ok := err.(RestError); ok && restErr.Err == ErrNotLoggedIn {
+		if token, renewErr := r.source.renewToken(ctx, token); renewErr == nil {
+			result = RawReply{}
+			err = r.retry.rest(ctx, r.client, r.method, url, token, r.query, r.request, &result)
+		}
+	}
+	return result, err
+}
+
+// fail stops the iteration with an error
+func (r *Reply) fail(err error) {
+	r.err = err
+	r.stopPrefetch()
+}
+
 // Error returns the last error in the stream
 func (r *Reply) Error() error {
 	return r.err
@@ -119,48 +250,207 @@ func (r *Reply) Error() error {
 // NewReply wraps a RawReply inside a Reply iterator
 func NewReply(r RawReply, err error) *Reply {
 	if err != nil {
-		return &Reply{err: err}
+		return &Reply{err: err, count: -1}
 	}
 	return &Reply{
 		current: []RawReply{r},
 		offset:  -1,
+		count:   -1,
+	}
+}
+
+// Selectors choose attributes from a RawReply object. They are
+// dot-separated paths of keys, optionally followed by array indexes,
+// with an optional alias for the column name:
+//
+//	mac_address
+//	attributes.Owner
+//	tags[0]
+//	attributes.*
+//	items[*].name
+//	owner=attributes.Owner
+//
+// Paths with wildcards ('*', '[*]') select a JSON array of all matches.
+
+// step in a selector path: a key, an array index, or a wildcard
+type step struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// Column returns the column name for a selector: its alias, or the path.
+func Column(selector string) string {
+	if parts := strings.SplitN(selector, "=", 2); len(parts) == 2 {
+		return strings.TrimSpace(parts[0])
+	}
+	return strings.TrimSpace(selector)
+}
+
+// parseSelector splits the path of a selector into steps
+func parseSelector(selector string) ([]step, error) {
+	if parts := strings.SplitN(selector, "=", 2); len(parts) == 2 {
+		selector = parts[1]
+	}
+	steps := make([]step, 0, 8)
+	for _, segment := range strings.Split(strings.TrimSpace(selector), ".") {
+		key := segment
+		if bracket := strings.Index(segment, "["); bracket >= 0 {
+			key = segment[:bracket]
+		}
+		switch key {
+		case "*":
+			steps = append(steps, step{wildcard: true})
+		case "":
+		default:
+			steps = append(steps, step{key: key})
+		}
+		for rest := segment[len(key):]; rest != ""; {
+			end := strings.Index(rest, "]")
+			if !strings.HasPrefix(rest, "[") || end < 0 {
+				return nil, fmt.Errorf("Invalid index in selector '%s'", selector)
+			}
+			index := rest[1:end]
+			if index == "*" {
+				steps = append(steps, step{wildcard: true})
+			} else {
+				i, err := strconv.Atoi(index)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid index in selector '%s': %s", selector, err)
+				}
+				steps = append(steps, step{index: i, isIndex: true})
+			}
+			rest = rest[end+1:]
+		}
+	}
+	return steps, nil
+}
+
+// members returns the values of an object or the items of an array, in order
+func members(data json.RawMessage) []json.RawMessage {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil
+	}
+	if token != json.Delim('{') && token != json.Delim('[') {
+		return nil
+	}
+	result := make([]json.RawMessage, 0, 16)
+	for decoder.More() {
+		if token == json.Delim('{') {
+			if _, err := decoder.Token(); err != nil {
+				return result
+			}
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return result
+		}
+		result = append(result, value)
+	}
+	return result
+}
+
+// follow a step from the given value
+func (s step) follow(data json.RawMessage) []json.RawMessage {
+	switch {
+	case s.wildcard:
+		return members(data)
+	case s.isIndex:
+		var list []json.RawMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil
+		}
+		index := s.index
+		if index < 0 {
+			index += len(list)
+		}
+		if index < 0 || index >= len(list) {
+			return nil
+		}
+		return list[index : index+1]
+	default:
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil
+		}
+		if value, ok := object[s.key]; ok {
+			return []json.RawMessage{value}
+		}
+		return nil
 	}
 }
 
 // Pick particular attributes from a RawReply object
-func pick(data map[string]json.RawMessage, attrib string) string {
-	parts := strings.Split(attrib, ".")
-	lenp := len(parts)
-	// If the string is a dot-separated path, go deep
-	for i := 0; i < lenp-1; i++ {
-		newData, ok := data[parts[i]]
-		if !ok {
-			return ""
+func pick(data json.RawMessage, steps []step) json.RawMessage {
+	current, wildcard := []json.RawMessage{data}, false
+	for _, s := range steps {
+		next := make([]json.RawMessage, 0, len(current))
+		for _, value := range current {
+			next = append(next, s.follow(value)...)
 		}
-		if err := json.Unmarshal(newData, &data); err != nil {
-			return ""
+		current, wildcard = next, wildcard || s.wildcard
+	}
+	if wildcard {
+		list, err := json.Marshal(current)
+		if err != nil {
+			return nil
 		}
+		return list
 	}
-	result, ok := data[parts[lenp-1]]
-	if !ok {
-		return ""
+	if len(current) != 1 {
+		return nil
 	}
-	repr, err := json.Marshal(result)
-	if err != nil {
-		return ""
+	return current[0]
+}
+
+// CheckSelectors returns an error if any selector is not valid
+func CheckSelectors(selectors []string) error {
+	for _, selector := range selectors {
+		if _, err := parseSelector(selector); err != nil {
+			return err
+		}
 	}
-	return string(repr)
+	return nil
 }
 
-// ToCSV returns a line with the selected attribs of the object
-func ToCSV(data RawReply, selectors []string) string {
-	result := make([]string, 0, len(selectors))
+// Select returns the raw values of the selected attributes of the object.
+// Attributes not found, or invalid selectors, are returned as nil.
+func Select(data RawReply, selectors []string) []json.RawMessage {
+	result := make([]json.RawMessage, 0, len(selectors))
 	var mapdata map[string]json.RawMessage
 	if err := json.Unmarshal(data, &mapdata); err != nil {
-		return ""
+		return nil
 	}
 	for _, attrib := range selectors {
-		result = append(result, pick(mapdata, attrib))
+		steps, err := parseSelector(attrib)
+		if err != nil {
+			result = append(result, nil)
+			continue
+		}
+		result = append(result, pick(data, steps))
+	}
+	return result
+}
+
+// ToCSV returns a line with the selected attribs of the object,
+// as JSON values separated by ';'. Legacy format, see term.Options.
+func ToCSV(data RawReply, selectors []string) string {
+	values := Select(data, selectors)
+	if values == nil {
+		return ""
+	}
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		repr := ""
+		if value != nil {
+			if text, err := json.Marshal(value); err == nil {
+				repr = string(text)
+			}
+		}
+		result = append(result, repr)
 	}
 	return strings.Join(result, ";")
 }
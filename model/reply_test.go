@@ -0,0 +1,75 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     []step
+		fails    bool
+	}{
+		{"mac_address", []step{{key: "mac_address"}}, false},
+		{"attributes.Owner", []step{{key: "attributes"}, {key: "Owner"}}, false},
+		{"owner = attributes.Owner", []step{{key: "attributes"}, {key: "Owner"}}, false},
+		{"tags[0]", []step{{key: "tags"}, {index: 0, isIndex: true}}, false},
+		{"tags[-1]", []step{{key: "tags"}, {index: -1, isIndex: true}}, false},
+		{"items[*].name", []step{{key: "items"}, {wildcard: true}, {key: "name"}}, false},
+		{"attributes.*", []step{{key: "attributes"}, {wildcard: true}}, false},
+		{"matrix[1][2]", []step{{key: "matrix"}, {index: 1, isIndex: true}, {index: 2, isIndex: true}}, false},
+		{"tags[x]", nil, true},
+		{"tags[0", nil, true},
+		{"tags[0]x", nil, true},
+	}
+	for _, test := range tests {
+		got, err := parseSelector(test.selector)
+		if (err != nil) != test.fails {
+			t.Errorf("parseSelector(%q) error = %v", test.selector, err)
+			continue
+		}
+		if !test.fails && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseSelector(%q) = %+v, want %+v", test.selector, got, test.want)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	data := RawReply(`{"name":"a","attributes":{"Owner":"me","Site":"HQ"},"tags":["x","y"],"items":[{"name":"i1"},{"id":2},{"name":"i3"}]}`)
+	tests := []struct {
+		selector string
+		want     string
+	}{
+		{"name", `"a"`},
+		{"attributes.Owner", `"me"`},
+		{"owner=attributes.Owner", `"me"`},
+		{"tags[1]", `"y"`},
+		{"tags[-1]", `"y"`},
+		{"tags[2]", ``},
+		{"items[*].name", `["i1","i3"]`},
+		{"attributes.*", `["me","HQ"]`},
+		{"missing", ``},
+		{"name.inner", ``},
+		{"tags[x]", ``},
+	}
+	for _, test := range tests {
+		got := Select(data, []string{test.selector})
+		if len(got) != 1 || string(got[0]) != test.want {
+			t.Errorf("Select(%q) = %s, want %s", test.selector, got, test.want)
+		}
+	}
+}
+
+func TestColumn(t *testing.T) {
+	tests := map[string]string{
+		"name":                   "name",
+		" owner = attributes.Ow": "owner",
+		"items[*].name":          "items[*].name",
+	}
+	for selector, want := range tests {
+		if got := Column(selector); got != want {
+			t.Errorf("Column(%q) = %q, want %q", selector, got, want)
+		}
+	}
+}
@@ -18,6 +18,29 @@ const ErrNotLoggedIn = Error("Not authorized. Make sure you log in and your acco
 // Params for ClearPass request
 type Params map[string]string
 
+// Status codes that each method may return on success
+var successCodes = map[Method][]int{
+	GET:    {200},
+	POST:   {200, 201},
+	PUT:    {200, 201, 204},
+	PATCH:  {200, 204},
+	DELETE: {200, 204},
+}
+
+// Check if the status code means success for the given method
+func succeeded(method Method, statusCode int) bool {
+	codes, ok := successCodes[method]
+	if !ok {
+		return statusCode == 200
+	}
+	for _, code := range codes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
 // Generic raw HTTP request
 func rawRequest(ctx context.Context, client *http.Client, req *http.Request, body []byte, stream bool) (RestError, io.ReadCloser) {
 	var bodyReader io.ReadCloser
@@ -95,10 +118,14 @@ func rest(ctx context.Context, client *http.Client, method Method, url, token st
 		detail.Err = ErrNotLoggedIn
 		return detail
 	}
-	if detail.StatusCode != 200 {
+	if !succeeded(method, detail.StatusCode) {
 		detail.Err = fmt.Errorf("Error: REST Status %d", detail.StatusCode)
 		return detail
 	}
+	// 204 No Content, or an empty body: nothing to unmarshal
+	if detail.StatusCode == 204 || len(bytes.TrimSpace(detail.Reply)) == 0 {
+		return nil
+	}
 	if err := json.Unmarshal(detail.Reply, reply); err != nil {
 		detail.Err = err
 		return detail
@@ -0,0 +1,28 @@
+package model
+
+import "testing"
+
+func TestSucceeded(t *testing.T) {
+	tests := []struct {
+		method Method
+		status int
+		want   bool
+	}{
+		{GET, 200, true},
+		{GET, 204, false},
+		{POST, 201, true},
+		{POST, 204, false},
+		{PUT, 201, true},
+		{PUT, 204, true},
+		{PATCH, 200, true},
+		{PATCH, 204, true},
+		{PATCH, 201, false},
+		{DELETE, 204, true},
+		{DELETE, 404, false},
+	}
+	for _, test := range tests {
+		if got := succeeded(test.method, test.status); got != test.want {
+			t.Errorf("succeeded(%s, %d) = %v, want %v", test.method, test.status, got, test.want)
+		}
+	}
+}
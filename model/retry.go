@@ -0,0 +1,126 @@
+package model
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy for transient failures: network errors, 429 Too Many
+// Requests and 5xx responses.
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts including the first one. <= 1 disables retries.
+	BaseDelay   time.Duration // Delay before the first retry, doubled on each attempt.
+	MaxDelay    time.Duration // Upper bound for the delay, and for the server's Retry-After.
+	RetryPOST   bool          // Retry POST requests too. They are not idempotent.
+}
+
+// maxRetryAfter bounds the Retry-After of the server when there is no MaxDelay
+const maxRetryAfter = 5 * time.Minute
+
+// DefaultRetryPolicy used when nothing else is configured
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// Attempt records a failed try of a request
+type Attempt struct {
+	Err        error
+	StatusCode int
+	Delay      time.Duration // Wait before the next attempt
+}
+
+// idempotent methods can be retried safely. PATCH is not: it may update
+// counters or lists, or race with changes made by others in between.
+var idempotent = map[Method]bool{
+	GET:    true,
+	PUT:    true,
+	DELETE: true,
+}
+
+// transient checks if the request failed for a reason worth retrying
+func transient(ctx context.Context, detail RestError) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if detail.StatusCode == 0 {
+		// No response at all: network error
+		return detail.Err != nil
+	}
+	return detail.StatusCode == http.StatusTooManyRequests || detail.StatusCode >= 500
+}
+
+// retryAfter parses the Retry-After header, in seconds or HTTP date
+func retryAfter(header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// delay before the given retry (1 for the first one), with jitter.
+// Returns false if the server asks to wait longer than allowed.
+func (p RetryPolicy) delay(retry int, header http.Header) (time.Duration, bool) {
+	if wait, ok := retryAfter(header); ok {
+		limit := p.MaxDelay
+		if limit <= 0 {
+			limit = maxRetryAfter
+		}
+		return wait, wait <= limit
+	}
+	delay := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	// Jitter between half and the full delay
+	if half := int64(delay / 2); half > 0 {
+		delay = time.Duration(half + rand.Int63n(half+1))
+	}
+	return delay, true
+}
+
+// rest performs a REST request, retrying transient failures
+func (p RetryPolicy) rest(ctx context.Context, client *http.Client, method Method, url, token string, query Params, request, reply interface{}) error {
+	canRetry := idempotent[method] || (method == POST && p.RetryPOST)
+	attempts := make([]Attempt, 0, p.MaxAttempts)
+	for attempt := 1; ; attempt++ {
+		err := rest(ctx, client, method, url, token, query, request, reply)
+		detail, ok := err.(RestError)
+		retry := ok && canRetry && attempt < p.MaxAttempts && transient(ctx, detail)
+		var delay time.Duration
+		if retry {
+			delay, retry = p.delay(attempt, detail.ReplyHeader)
+		}
+		if !retry {
+			if ok && len(attempts) > 0 {
+				detail.Attempts = append(attempts, Attempt{Err: detail.Err, StatusCode: detail.StatusCode})
+				return detail
+			}
+			return err
+		}
+		attempts = append(attempts, Attempt{Err: detail.Err, StatusCode: detail.StatusCode, Delay: delay})
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			detail.Attempts = attempts
+			return detail
+		}
+	}
+}
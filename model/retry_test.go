@@ -0,0 +1,113 @@
+package model
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", 0, true},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.value != "" {
+			header.Set("Retry-After", test.value)
+		}
+		got, ok := retryAfter(header)
+		if got != test.want || ok != test.ok {
+			t.Errorf("retryAfter(%q) = %s, %v, want %s, %v", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	tests := []struct {
+		retry      int
+		retryAfter string
+		min, max   time.Duration
+		ok         bool
+	}{
+		{1, "", 500 * time.Millisecond, time.Second, true},
+		{2, "", time.Second, 2 * time.Second, true},
+		{3, "", 2 * time.Second, 4 * time.Second, true},
+		{10, "", 2500 * time.Millisecond, 5 * time.Second, true},
+		{1, "4", 4 * time.Second, 4 * time.Second, true},
+		{1, "5", 5 * time.Second, 5 * time.Second, true},
+		{1, "6", 6 * time.Second, 6 * time.Second, false},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.retryAfter != "" {
+			header.Set("Retry-After", test.retryAfter)
+		}
+		got, ok := policy.delay(test.retry, header)
+		if got < test.min || got > test.max || ok != test.ok {
+			t.Errorf("delay(%d, %q) = %s, %v, want [%s, %s], %v", test.retry, test.retryAfter, got, ok, test.min, test.max, test.ok)
+		}
+	}
+	// Without MaxDelay, Retry-After is still bounded
+	if _, ok := (RetryPolicy{}).delay(1, http.Header{"Retry-After": []string{"3600"}}); ok {
+		t.Error("delay accepted a Retry-After of one hour")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     Method
+		policy     RetryPolicy
+		failures   int32
+		retryAfter string
+		requests   int32
+		fails      bool
+	}{
+		{"GET recovers", GET, RetryPolicy{MaxAttempts: 3}, 2, "", 3, false},
+		{"GET gives up", GET, RetryPolicy{MaxAttempts: 2}, 5, "", 2, true},
+		{"PATCH not retried", PATCH, RetryPolicy{MaxAttempts: 3}, 1, "", 1, true},
+		{"POST not retried", POST, RetryPolicy{MaxAttempts: 3}, 1, "", 1, true},
+		{"POST opted in", POST, RetryPolicy{MaxAttempts: 3, RetryPOST: true}, 1, "", 2, false},
+		{"negative attempts", GET, RetryPolicy{MaxAttempts: -1}, 1, "", 1, true},
+		{"Retry-After too long", GET, RetryPolicy{MaxAttempts: 3, MaxDelay: time.Second}, 1, "60", 1, true},
+		{"Retry-After allowed", GET, RetryPolicy{MaxAttempts: 3, MaxDelay: time.Second}, 1, "0", 2, false},
+	}
+	for _, test := range tests {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) <= test.failures {
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":1}`))
+		}))
+		test.policy.BaseDelay = time.Millisecond
+		reply := Request(server.Client(), test.method, server.URL, "token", nil, map[string]string{}).SetRetry(test.policy)
+		for reply.Next(context.Background()) {
+		}
+		server.Close()
+		if fails := reply.Error() != nil; fails != test.fails {
+			t.Errorf("%s: got error %v", test.name, reply.Error())
+		}
+		if requests != test.requests {
+			t.Errorf("%s: got %d requests, want %d", test.name, requests, test.requests)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Trace levels
+const (
+	TraceOff    = 0 // No tracing
+	TraceLines  = 1 // Request line, status, timing
+	TraceBodies = 2 // Headers and bodies too
+)
+
+// traceMaxBody is the max size of the bodies logged
+const traceMaxBody = 16 << 10
+
+// Trace configures the tracing of HTTP requests. Secrets are masked, unless ShowSecrets.
+type Trace struct {
+	Level int         // TraceOff, TraceLines or TraceBodies
+	Curl  bool        // Log an equivalent curl command for each request
+	Log   *log.Logger // Destination of the traces. Default stderr.
+}
+
+// tracer is a RoundTripper that logs the requests and replies
+type tracer struct {
+	Trace
+	next   http.RoundTripper
+	unsafe bool
+}
+
+// textual checks if the content type is worth logging
+func textual(header http.Header) bool {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	for _, kind := range []string{"json", "text", "form-urlencoded", "xml", "javascript"} {
+		if strings.Contains(contentType, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// traceHeader writes the header lines, sorted, with the given prefix
+func traceHeader(w *bytes.Buffer, prefix string, header http.Header) {
+	header = RedactHeader(header)
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(w, "%s %s: %s\n", prefix, name, value)
+		}
+	}
+}
+
+// traceBody writes the body, redacted and truncated
+func traceBody(w *bytes.Buffer, prefix string, header http.Header, body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	if !textual(header) {
+		fmt.Fprintf(w, "%s [%d bytes of %s]\n", prefix, len(body), header.Get("Content-Type"))
+		return
+	}
+	text := RedactBody(body)
+	suffix := ""
+	if len(text) > traceMaxBody {
+		text, suffix = text[:traceMaxBody], fmt.Sprintf(" ... [%d bytes]", len(body))
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(text), "\n"), "\n") {
+		fmt.Fprintf(w, "%s %s\n", prefix, line)
+	}
+	if suffix != "" {
+		fmt.Fprintf(w, "%s%s\n", prefix, suffix)
+	}
+}
+
+// shellQuote quotes the text for a POSIX shell
+func shellQuote(text string) string {
+	return "'" + strings.Replace(text, "'", `'\''`, -1) + "'"
+}
+
+// curl builds an equivalent curl command for the request
+func (t *tracer) curl(req *http.Request, body []byte) string {
+	args := []string{"curl"}
+	if t.unsafe {
+		args = append(args, "-k")
+	}
+	args = append(args, "-X", req.Method, shellQuote(RedactURL(req.URL.String())))
+	header := RedactHeader(req.Header)
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			args = append(args, "-H", shellQuote(name+": "+value))
+		}
+	}
+	if len(body) > 0 {
+		if textual(req.Header) {
+			args = append(args, "--data-binary", shellQuote(string(RedactBody(body))))
+		} else {
+			args = append(args, "--data-binary", "@-")
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// logger returns the destination of the traces
+func (t *tracer) logger() *log.Logger {
+	if t.Log == nil {
+		return log.New(os.Stderr, "", 0)
+	}
+	return t.Log
+}
+
+// printf logs a message when tracing is enabled (-v)
+func (t *tracer) printf(format string, args ...interface{}) {
+	if t.Level > TraceOff {
+		t.logger().Printf(format, args...)
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *tracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Level <= TraceOff && !t.Curl {
+		return t.next.RoundTrip(req)
+	}
+	logger := t.logger()
+	var body []byte
+	if req.Body != nil {
+		data, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = data
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if t.Curl {
+		logger.Print(t.curl(req, body))
+	}
+	if t.Level <= TraceOff {
+		return t.next.RoundTrip(req)
+	}
+	// Log the whole request and reply at once, so that
+	// concurrent requests are not mixed up.
+	w := &bytes.Buffer{}
+	fmt.Fprintf(w, "> %s %s\n", req.Method, RedactURL(req.URL.String()))
+	if t.Level >= TraceBodies {
+		traceHeader(w, ">", req.Header)
+		traceBody(w, ">", req.Header, body)
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		fmt.Fprintf(w, "< %s (%s)", err, elapsed)
+		logger.Print(w.String())
+		return resp, err
+	}
+	fmt.Fprintf(w, "< %s (%s)\n", resp.Status, elapsed)
+	if t.Level >= TraceBodies {
+		traceHeader(w, "<", resp.Header)
+		if resp.Body != nil && textual(resp.Header) {
+			reply, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				fmt.Fprintf(w, "< %s", err)
+				logger.Print(w.String())
+				return nil, err
+			}
+			resp.Body = ioutil.NopCloser(bytes.NewReader(reply))
+			traceBody(w, "<", resp.Header, reply)
+		}
+	}
+	logger.Print(strings.TrimRight(w.String(), "\n"))
+	return resp, nil
+}
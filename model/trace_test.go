@@ -0,0 +1,140 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", `''`},
+		{"abc", `'abc'`},
+		{"a b", `'a b'`},
+		{"it's", `'it'\''s'`},
+		{"''", `''\'''\'''`},
+		{`$HOME "x"`, `'$HOME "x"'`},
+	}
+	for _, test := range tests {
+		if got := shellQuote(test.text); got != test.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", test.text, got, test.want)
+		}
+	}
+}
+
+func TestCurl(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header http.Header
+		body   string
+		unsafe bool
+		want   string
+	}{
+		{
+			"get", "GET", "https://cppm/api/endpoint?limit=1",
+			http.Header{"Authorization": {"Bearer abc"}}, "", false,
+			`curl -X GET 'https://cppm/api/endpoint?limit=1' -H 'Authorization: Bearer [REDACTED]'`,
+		},
+		{
+			"post", "POST", "https://cppm/api/endpoint",
+			http.Header{"Content-Type": {"application/json"}, "Accept": {"application/json"}}, `{"mac_address":"00:11:22:33:44:55","password":"it's"}`, true,
+			`curl -k -X POST 'https://cppm/api/endpoint' -H 'Accept: application/json' -H 'Content-Type: application/json' --data-binary '{"mac_address":"00:11:22:33:44:55","password":"[REDACTED]"}'`,
+		},
+		{
+			"binary body", "POST", "https://cppm/api/import?token=abc",
+			http.Header{"Content-Type": {"application/octet-stream"}}, "\x00\x01", false,
+			`curl -X POST 'https://cppm/api/import?token=[REDACTED]' -H 'Content-Type: application/octet-stream' --data-binary @-`,
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = test.header
+		tracer := &tracer{unsafe: test.unsafe}
+		if got := tracer.curl(req, []byte(test.body)); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTraceBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		body   string
+		want   string
+	}{
+		{"empty", nil, "", ""},
+		{"json", http.Header{"Content-Type": {"application/json"}}, "{\n\"token\": \"abc\"\n}", "> {\n> \"token\": \"[REDACTED]\"\n> }\n"},
+		{"no content type", nil, "a=1", "> a=1\n"},
+		{"binary", http.Header{"Content-Type": {"application/zip"}}, "PK\x03\x04", "> [4 bytes of application/zip]\n"},
+	}
+	for _, test := range tests {
+		w := &bytes.Buffer{}
+		traceBody(w, ">", test.header, []byte(test.body))
+		if w.String() != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, w.String(), test.want)
+		}
+	}
+}
+
+func TestTraceHeader(t *testing.T) {
+	w := &bytes.Buffer{}
+	traceHeader(w, "<", http.Header{
+		"Set-Cookie":   {"PHPSESSID=1"},
+		"Content-Type": {"application/json"},
+	})
+	want := "< Content-Type: application/json\n< Set-Cookie: [REDACTED]\n"
+	if w.String() != want {
+		t.Errorf("got %q, want %q", w.String(), want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"xyz"}`)
+	}))
+	defer server.Close()
+	tests := []struct {
+		trace   Trace
+		want    []string
+		notWant []string
+	}{
+		{Trace{Level: TraceOff}, nil, []string{"POST"}},
+		{Trace{Level: TraceLines}, []string{"> POST " + server.URL, "< 200 OK"}, []string{"secret", "access_token"}},
+		{Trace{Level: TraceBodies}, []string{`"client_secret":"[REDACTED]"`, `"access_token":"[REDACTED]"`}, []string{"s3cr3t", "xyz"}},
+		{Trace{Curl: true}, []string{"curl -X POST"}, []string{"< 200", "s3cr3t"}},
+	}
+	for _, test := range tests {
+		output := &bytes.Buffer{}
+		test.trace.Log = log.New(output, "", 0)
+		client := &http.Client{Transport: &tracer{Trace: test.trace, next: http.DefaultTransport}}
+		resp, err := client.Post(server.URL+"/api/oauth", "application/json", strings.NewReader(`{"client_secret":"s3cr3t"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		for _, text := range test.want {
+			if !strings.Contains(output.String(), text) {
+				t.Errorf("trace %+v: %q missing in %q", test.trace, text, output.String())
+			}
+		}
+		for _, text := range test.notWant {
+			if strings.Contains(output.String(), text) {
+				t.Errorf("trace %+v: %q found in %q", test.trace, text, output.String())
+			}
+		}
+	}
+}
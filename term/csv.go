@@ -0,0 +1,214 @@
+package term
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rafahpe/cpcli/model"
+)
+
+// Rendering of nested objects and arrays in CSV cells
+const (
+	// NestedJSON renders nested values as JSON-encoded cells
+	NestedJSON = "json"
+	// NestedJoin renders arrays as a list of items separated by ListSeparator.
+	// Objects are still JSON-encoded.
+	NestedJoin = "join"
+)
+
+// ListSeparator for arrays rendered with NestedJoin
+const ListSeparator = ","
+
+// ErrInvalidDelimiter when the CSV delimiter is not a single character
+const ErrInvalidDelimiter = Error("CSV delimiter must be a single character")
+
+// ErrInvalidNested when the rendering of nested values is unknown
+const ErrInvalidNested = Error("Nested values must be rendered as 'json' or 'join'")
+
+// checkNested checks the rendering of nested values in the options.
+// Default NestedJSON.
+func (options Options) checkNested() error {
+	switch options.Nested {
+	case "", NestedJSON, NestedJoin:
+		return nil
+	}
+	return ErrInvalidNested
+}
+
+// delimiter returns the CSV delimiter from the options. Default ','
+func (options Options) delimiter() (rune, error) {
+	switch options.Delimiter {
+	case "":
+		return ',', nil
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(options.Delimiter)
+	if r == utf8.RuneError || size != len(options.Delimiter) {
+		return 0, ErrInvalidDelimiter
+	}
+	return r, nil
+}
+
+// toCSV renders a single CSV record, without trailing newline
+func toCSV(options Options, record []string) (string, error) {
+	delim, err := options.delimiter()
+	if err != nil {
+		return "", err
+	}
+	buffer := &bytes.Buffer{}
+	w := csv.NewWriter(buffer)
+	w.Comma = delim
+	if err := w.Write(record); err != nil {
+		return "", err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+// cells renders the selected values as CSV cells
+func cells(options Options, values []json.RawMessage) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		result = append(result, cell(options, value))
+	}
+	return result
+}
+
+// cell renders a JSON value as a CSV cell: strings unquoted,
+// numbers and booleans as is, nulls and missing values empty.
+func cell(options Options, value json.RawMessage) string {
+	if len(value) == 0 {
+		return ""
+	}
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return string(value)
+	}
+	if list, ok := v.([]interface{}); ok && options.Nested == NestedJoin {
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			items = append(items, scalar(item))
+		}
+		return strings.Join(items, ListSeparator)
+	}
+	return scalar(v)
+}
+
+// scalar renders a decoded JSON value. Objects and arrays are JSON-encoded.
+func scalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	text, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(text)
+}
+
+// delimitedFormatter writes one line per item, with a header line
+type delimitedFormatter struct {
+	w io.Writer
+	rows
+	header bool // header already written, or skipped
+	record func(options Options, fields []string) (string, error)
+}
+
+// tsvEscaper escapes the characters not allowed in TSV fields
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// toTSV renders a TSV record, escaping tabs and newlines
+func toTSV(options Options, fields []string) (string, error) {
+	escaped := make([]string, 0, len(fields))
+	for _, field := range fields {
+		escaped = append(escaped, tsvEscaper.Replace(field))
+	}
+	return strings.Join(escaped, "\t"), nil
+}
+
+// toLegacyCSV renders fields separated by ';', without quoting
+func toLegacyCSV(options Options, fields []string) (string, error) {
+	return strings.Join(fields, ";"), nil
+}
+
+func init() {
+	RegisterFormat("csv", func(w io.Writer, options Options, columns []string) Formatter {
+		if options.LegacyCSV {
+			return &legacyFormatter{delimitedFormatter{w: w, rows: rows{options: options, columns: columns}, header: options.SkipHeaders, record: toLegacyCSV}}
+		}
+		return &delimitedFormatter{w: w, rows: rows{options: options, columns: columns}, header: options.SkipHeaders, record: toCSV}
+	})
+	RegisterFormat("tsv", func(w io.Writer, options Options, columns []string) Formatter {
+		return &delimitedFormatter{w: w, rows: rows{options: options, columns: columns}, header: options.SkipHeaders, record: toTSV}
+	})
+}
+
+// writeHeader writes the header line, if not written yet
+func (f *delimitedFormatter) writeHeader() error {
+	if f.header || len(f.columns) == 0 {
+		return nil
+	}
+	f.header = true
+	return f.writeRecord(header(f.columns))
+}
+
+// writeRecord writes a line
+func (f *delimitedFormatter) writeRecord(fields []string) error {
+	line, err := f.record(f.options, fields)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f.w, line)
+	return err
+}
+
+// Write implements Formatter
+func (f *delimitedFormatter) Write(item model.RawReply) error {
+	cells := f.cells(item)
+	if err := f.writeHeader(); err != nil {
+		return err
+	}
+	return f.writeRecord(cells)
+}
+
+// Flush implements Formatter
+func (f *delimitedFormatter) Flush() error {
+	return f.writeHeader()
+}
+
+// legacyFormatter writes the selected attributes as JSON values separated
+// by ';', the way older versions did.
+type legacyFormatter struct {
+	delimitedFormatter
+}
+
+// Write implements Formatter
+func (f *legacyFormatter) Write(item model.RawReply) error {
+	if len(f.columns) == 0 {
+		f.columns = objectKeys(item)
+	}
+	if err := f.writeHeader(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(f.w, model.ToCSV(item, f.columns))
+	return err
+}
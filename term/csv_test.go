@@ -0,0 +1,57 @@
+package term
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestToCSV(t *testing.T) {
+	tests := []struct {
+		delimiter string
+		record    []string
+		want      string
+		fails     bool
+	}{
+		{"", []string{"a", "b"}, "a,b", false},
+		{"", []string{"a,b", `say "hi"`}, `"a,b","say ""hi"""`, false},
+		{"", []string{"two\nlines", ""}, "\"two\nlines\",", false},
+		{";", []string{"a;b", "c,d"}, `"a;b";c,d`, false},
+		{`\t`, []string{"a", "b"}, "a\tb", false},
+		{"tab", []string{"a", "b"}, "a\tb", false},
+		{";;", []string{"a"}, "", true},
+	}
+	for _, test := range tests {
+		got, err := toCSV(Options{Delimiter: test.delimiter}, test.record)
+		if (err != nil) != test.fails {
+			t.Errorf("toCSV(%q, %q) error = %v", test.delimiter, test.record, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("toCSV(%q, %q) = %q, want %q", test.delimiter, test.record, got, test.want)
+		}
+	}
+}
+
+func TestCell(t *testing.T) {
+	tests := []struct {
+		nested string
+		value  string
+		want   string
+	}{
+		{"", ``, ""},
+		{"", `null`, ""},
+		{"", `"text"`, "text"},
+		{"", `12345678901234567890`, "12345678901234567890"},
+		{"", `1.50`, "1.50"},
+		{"", `true`, "true"},
+		{"", `["a","b"]`, `["a","b"]`},
+		{"", `{"a":1}`, `{"a":1}`},
+		{NestedJoin, `["a",2,null]`, "a,2,"},
+		{NestedJoin, `{"a":1}`, `{"a":1}`},
+	}
+	for _, test := range tests {
+		if got := cell(Options{Nested: test.nested}, json.RawMessage(test.value)); got != test.want {
+			t.Errorf("cell(%q, %s) = %q, want %q", test.nested, test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package term
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// InputCSV is a CSV file with a header row. Each row is a request body.
+const InputCSV = "csv"
+
+// Type hints for CSV columns, e.g. "attributes.Enabled:bool".
+// Columns without hint are strings.
+const (
+	hintString = "string"
+	hintInt    = "int"
+	hintFloat  = "float"
+	hintBool   = "bool"
+	hintJSON   = "json"
+)
+
+// csvColumn is a column of the CSV input
+type csvColumn struct {
+	path []string // Path of the attribute, split by '.'
+	hint string
+}
+
+// csvInput reads request bodies from CSV rows
+type csvInput struct {
+	reader  *csv.Reader
+	header  []string
+	columns []csvColumn
+	record  []string // Row of the current body
+	current json.RawMessage
+	err     error
+}
+
+// newCSVInput reads the header row, and returns the Input
+func newCSVInput(r io.Reader) (Input, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return &sliceInput{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]csvColumn, 0, len(header))
+	for i, name := range header {
+		if i == 0 {
+			// Spreadsheets may add a byte order mark
+			name = strings.TrimPrefix(name, "\uFEFF")
+			header[i] = name
+		}
+		column, err := parseColumn(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return &csvInput{reader: reader, header: header, columns: columns}, nil
+}
+
+// parseColumn parses a header like "attributes.Owner" or "enabled:bool".
+// The suffix after the last ':' is only a type if it is a known one,
+// so attributes like "a:b" can be set too.
+func parseColumn(name string) (csvColumn, error) {
+	hint := hintString
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		switch suffix := strings.ToLower(name[i+1:]); suffix {
+		case hintString, hintInt, hintFloat, hintBool, hintJSON:
+			name, hint = name[:i], suffix
+		}
+	}
+	if name == "" {
+		return csvColumn{}, Error("Empty column name in CSV header")
+	}
+	return csvColumn{path: strings.Split(name, "."), hint: hint}, nil
+}
+
+// value converts the cell to the type of the column
+func (c csvColumn) value(cell string) (interface{}, error) {
+	switch c.hint {
+	case hintInt:
+		// Reformatted, JSON does not allow "+1" or "007"
+		n, err := strconv.ParseInt(cell, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatInt(n, 10)), nil
+	case hintFloat:
+		f, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("Invalid number '%s'", cell)
+		}
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+	case hintBool:
+		switch strings.ToLower(cell) {
+		case "yes", "y", "on":
+			return true, nil
+		case "no", "n", "off":
+			return false, nil
+		}
+		return strconv.ParseBool(cell)
+	case hintJSON:
+		if !json.Valid([]byte(cell)) {
+			return nil, Error("Invalid JSON value")
+		}
+		return json.RawMessage(cell), nil
+	}
+	return cell, nil
+}
+
+// set stores the value in the object, following the path
+func set(object map[string]interface{}, path []string, value interface{}) error {
+	for _, step := range path[:len(path)-1] {
+		inner, ok := object[step]
+		if !ok {
+			inner = make(map[string]interface{})
+			object[step] = inner
+		}
+		if object, ok = inner.(map[string]interface{}); !ok {
+			return fmt.Errorf("Attribute '%s' is both a value and an object", step)
+		}
+	}
+	last := path[len(path)-1]
+	if _, ok := object[last]; ok {
+		return fmt.Errorf("Attribute '%s' is set twice", last)
+	}
+	object[last] = value
+	return nil
+}
+
+// Next implements Input. Empty cells are skipped, so they can't
+// overwrite attributes in PATCH requests.
+func (i *csvInput) Next() bool {
+	if i.err != nil {
+		return false
+	}
+	row, err := i.reader.Read()
+	if err != nil {
+		if err != io.EOF {
+			i.err = err
+		}
+		return false
+	}
+	i.record = row
+	object := make(map[string]interface{})
+	for index, cell := range row {
+		// Quoted cells may span several lines
+		line, _ := i.reader.FieldPos(index)
+		if index >= len(i.columns) {
+			i.err = fmt.Errorf("Line %d: more cells than columns in the header", line)
+			return false
+		}
+		if cell == "" {
+			continue
+		}
+		column := i.columns[index]
+		value, err := column.value(cell)
+		if err != nil {
+			i.err = fmt.Errorf("Line %d, column '%s': %s", line, strings.Join(column.path, "."), err)
+			return false
+		}
+		if err := set(object, column.path, value); err != nil {
+			i.err = fmt.Errorf("Line %d: %s", line, err)
+			return false
+		}
+	}
+	if i.current, i.err = json.Marshal(object); i.err != nil {
+		return false
+	}
+	return true
+}
+
+// Get implements Input
+func (i *csvInput) Get() json.RawMessage {
+	return i.current
+}
+
+// Error implements Input
+func (i *csvInput) Error() error {
+	return i.err
+}
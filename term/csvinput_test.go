@@ -0,0 +1,131 @@
+package term
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseColumn(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		hint  string
+		fails bool
+	}{
+		{"name", "name", hintString, false},
+		{"attributes.Enabled:bool", "attributes.Enabled", hintBool, false},
+		{"vlan:INT", "vlan", hintInt, false},
+		{"url:string", "url", hintString, false},
+		{"a:b:json", "a:b", hintJSON, false},
+		{"a:b", "a:b", hintString, false},
+		{"size:bytes", "size:bytes", hintString, false},
+		{"urn:oid:1.2", "urn:oid:1.2", hintString, false},
+		{":int", "", "", true},
+		{"", "", "", true},
+	}
+	for _, test := range tests {
+		got, err := parseColumn(test.name)
+		if (err != nil) != test.fails {
+			t.Errorf("parseColumn(%q) error = %v", test.name, err)
+			continue
+		}
+		if !test.fails && (strings.Join(got.path, ".") != test.path || got.hint != test.hint) {
+			t.Errorf("parseColumn(%q) = %v:%s, want %s:%s", test.name, got.path, got.hint, test.path, test.hint)
+		}
+	}
+}
+
+func TestCSVValue(t *testing.T) {
+	tests := []struct {
+		hint  string
+		cell  string
+		want  string
+		fails bool
+	}{
+		{hintString, "007", `"007"`, false},
+		{hintInt, "007", `7`, false},
+		{hintInt, "+42", `42`, false},
+		{hintInt, "4.2", ``, true},
+		{hintFloat, "4.20", `4.2`, false},
+		{hintFloat, "1e3", `1000`, false},
+		{hintFloat, "NaN", ``, true},
+		{hintFloat, "-Inf", ``, true},
+		{hintBool, "Yes", `true`, false},
+		{hintBool, "off", `false`, false},
+		{hintBool, "TRUE", `true`, false},
+		{hintBool, "maybe", ``, true},
+		{hintJSON, `["a",1]`, `["a",1]`, false},
+		{hintJSON, `{`, ``, true},
+	}
+	for _, test := range tests {
+		input, err := newCSVInput(strings.NewReader("value:" + test.hint + "\n" + `"` + strings.Replace(test.cell, `"`, `""`, -1) + "\"\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok := input.Next()
+		if fails := !ok && input.Error() != nil; fails != test.fails {
+			t.Errorf("%s %q: error = %v", test.hint, test.cell, input.Error())
+			continue
+		}
+		if want := `{"value":` + test.want + `}`; !test.fails && string(input.Get()) != want {
+			t.Errorf("%s %q = %s, want %s", test.hint, test.cell, input.Get(), want)
+		}
+	}
+}
+
+func TestCSVErrorLines(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"n:int\n1\nx\n", "Line 3, column 'n'"},
+		{"note,n:int\n\"a\nb\nc\",1\nd,x\n", "Line 5, column 'n'"},
+		{"note,n:int\n\"a\nb\",x\n", "Line 3, column 'n'"},
+		{"n\n\"a\nb\",c\n", "Line 3: more cells"},
+	}
+	for _, test := range tests {
+		input, err := newCSVInput(strings.NewReader(test.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for input.Next() {
+		}
+		if err := input.Error(); err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%q: got error %v, want %s", test.data, err, test.want)
+		}
+	}
+}
+
+func TestCSVInput(t *testing.T) {
+	tests := []struct {
+		data  string
+		want  []string
+		fails bool
+	}{
+		{"", nil, false},
+		{"\uFEFFname,attributes.Owner,attributes.Enabled:bool\nr1,me,yes\nr2,,\n", []string{
+			`{"attributes":{"Enabled":true,"Owner":"me"},"name":"r1"}`,
+			`{"name":"r2"}`,
+		}, false},
+		{"name,name\na,b\n", nil, true},
+		{"a,a.b\nx,y\n", nil, true},
+		{"name\na,b\n", nil, true},
+	}
+	for _, test := range tests {
+		input, err := newCSVInput(strings.NewReader(test.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(test.want))
+		for input.Next() {
+			got = append(got, string(input.Get()))
+		}
+		if (input.Error() != nil) != test.fails {
+			t.Errorf("%q: error = %v", test.data, input.Error())
+			continue
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%q = %v, want %v", test.data, got, test.want)
+		}
+	}
+}
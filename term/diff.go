@@ -0,0 +1,134 @@
+package term
+
+import (
+	"fmt"
+	"io"
+)
+
+// lineOp is a step of the edit script between two texts
+type lineOp struct {
+	kind byte // ' ' for a common line, '-' removed, '+' added
+	text string
+}
+
+// diffLines returns the edit script from a to b, keeping
+// their longest common subsequence of lines.
+func diffLines(a, b []string) []lineOp {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	ops := make([]lineOp, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, lineOp{kind: ' ', text: a[i]})
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, lineOp{kind: '-', text: a[i]})
+			i++
+		default:
+			ops = append(ops, lineOp{kind: '+', text: b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, lineOp{kind: '-', text: a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, lineOp{kind: '+', text: b[j]})
+	}
+	return ops
+}
+
+// hunkRange formats the start and length of a hunk, as diff -u does
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// Unified writes the differences between the lines of a and b in unified
+// diff format, with the given lines of context. Nothing is written if
+// they are equal.
+func Unified(w io.Writer, fromName, toName string, a, b []string, context int) error {
+	ops := diffLines(a, b)
+	// Lines of a and b before each step
+	aPos, bPos := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	changed := false
+	for k, op := range ops {
+		aPos[k+1], bPos[k+1] = aPos[k], bPos[k]
+		if op.kind != '+' {
+			aPos[k+1]++
+		}
+		if op.kind != '-' {
+			bPos[k+1]++
+		}
+		changed = changed || op.kind != ' '
+	}
+	if !changed {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", fromName, toName); err != nil {
+		return err
+	}
+	for k := 0; k < len(ops); {
+		for k < len(ops) && ops[k].kind == ' ' {
+			k++
+		}
+		if k == len(ops) {
+			break
+		}
+		start, end := k-context, k
+		if start < 0 {
+			start = 0
+		}
+		// Join the changes closer than twice the context
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				if end += context; end > len(ops) {
+					end = len(ops)
+				}
+				break
+			}
+			end = run
+		}
+		header := fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(aPos[start], aPos[end]-aPos[start]), hunkRange(bPos[start], bPos[end]-bPos[start]))
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+		for _, op := range ops[start:end] {
+			if _, err := fmt.Fprintf(w, "%c%s\n", op.kind, op.text); err != nil {
+				return err
+			}
+		}
+		k = end
+	}
+	return nil
+}
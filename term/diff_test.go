@@ -0,0 +1,77 @@
+package term
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		common int // Length of the longest common subsequence
+	}{
+		{"", "", 0},
+		{"a b c", "a b c", 3},
+		{"a b c", "", 0},
+		{"", "a b c", 0},
+		{"a b c", "a x c", 2},
+		{"a b c a b b a", "c b a b a c", 4},
+		{"x a b", "a b x", 2},
+	}
+	for _, test := range tests {
+		a, b := strings.Fields(test.a), strings.Fields(test.b)
+		ops := diffLines(a, b)
+		from, to := make([]string, 0, len(a)), make([]string, 0, len(b))
+		common := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				from = append(from, op.text)
+			}
+			if op.kind != '-' {
+				to = append(to, op.text)
+			}
+			if op.kind == ' ' {
+				common++
+			}
+		}
+		if strings.Join(from, " ") != strings.Join(a, " ") || strings.Join(to, " ") != strings.Join(b, " ") {
+			t.Errorf("diffLines(%q, %q) does not rebuild the texts: %v", test.a, test.b, ops)
+		}
+		if common != test.common {
+			t.Errorf("diffLines(%q, %q) kept %d lines, want %d", test.a, test.b, common, test.common)
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	numbers := strings.Fields("1 2 3 4 5 6 7 8 9 10")
+	changed := strings.Fields("1 two 3 4 5 6 7 8 nine 10")
+	tests := []struct {
+		name    string
+		a, b    []string
+		context int
+		want    string
+	}{
+		{"equal", numbers, numbers, 3, ""},
+		{"changed line", []string{"a", "b", "c"}, []string{"a", "x", "c"}, 3,
+			"--- from\n+++ to\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"},
+		{"added", nil, []string{"x"}, 3,
+			"--- from\n+++ to\n@@ -0,0 +1 @@\n+x\n"},
+		{"removed", []string{"x", "y"}, nil, 3,
+			"--- from\n+++ to\n@@ -1,2 +0,0 @@\n-x\n-y\n"},
+		{"two hunks", numbers, changed, 1,
+			"--- from\n+++ to\n@@ -1,3 +1,3 @@\n 1\n-2\n+two\n 3\n@@ -8,3 +8,3 @@\n 8\n-9\n+nine\n 10\n"},
+		{"joined hunks", numbers, changed, 3,
+			"--- from\n+++ to\n@@ -1,10 +1,10 @@\n 1\n-2\n+two\n 3\n 4\n 5\n 6\n 7\n 8\n-9\n+nine\n 10\n"},
+	}
+	for _, test := range tests {
+		buffer := &bytes.Buffer{}
+		if err := Unified(buffer, "from", "to", test.a, test.b, test.context); err != nil {
+			t.Fatal(err)
+		}
+		if got := buffer.String(); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package term
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rafahpe/cpcli/model"
+)
+
+// Formatter renders a stream of replies
+type Formatter interface {
+	// Write renders an item. Formatters may buffer the output.
+	Write(item model.RawReply) error
+	// Flush any buffered output, and the header if not written yet.
+	// Called before pausing for pagination, and at the end of the stream.
+	Flush() error
+}
+
+// FormatterFactory creates a Formatter that writes to w.
+// 'columns' are the attributes selected in the command line, may be empty.
+type FormatterFactory func(w io.Writer, options Options, columns []string) Formatter
+
+// Registered formatters
+var formatters = make(map[string]FormatterFactory)
+
+// RegisterFormat makes a formatter available for the --output flag
+func RegisterFormat(name string, factory FormatterFactory) {
+	formatters[name] = factory
+}
+
+// Formats returns the names of the registered formats
+func Formats() []string {
+	names := make([]string, 0, len(formatters))
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newFormatter builds the formatter selected in the options. Default is
+// "csv" if there are columns selected, "json" otherwise.
+func newFormatter(w io.Writer, options Options, columns []string) (Formatter, error) {
+	name := options.Output
+	if name == "" {
+		name = "json"
+		if len(columns) > 0 {
+			name = "csv"
+		}
+	}
+	factory, ok := formatters[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown output format '%s', must be one of: %s", name, strings.Join(Formats(), ", "))
+	}
+	if err := options.checkNested(); err != nil {
+		return nil, err
+	}
+	return factory(w, options, columns), nil
+}
+
+// jsonFormatter writes one JSON document per item
+type jsonFormatter struct {
+	w       io.Writer
+	pretty  bool
+	columns []string
+}
+
+func init() {
+	RegisterFormat("json", func(w io.Writer, options Options, columns []string) Formatter {
+		return jsonFormatter{w: w, pretty: options.PrettyPrint, columns: columns}
+	})
+	RegisterFormat("ndjson", func(w io.Writer, options Options, columns []string) Formatter {
+		return jsonFormatter{w: w, columns: columns}
+	})
+}
+
+// Write implements Formatter
+func (f jsonFormatter) Write(item model.RawReply) error {
+	if len(f.columns) > 0 {
+		item = selectObject(item, f.columns)
+	}
+	var output []byte
+	var err error
+	if f.pretty {
+		output, err = json.MarshalIndent(item, "", "  ")
+	} else {
+		output, err = json.Marshal(item)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f.w, string(output))
+	return err
+}
+
+// Flush implements Formatter
+func (f jsonFormatter) Flush() error {
+	return nil
+}
+
+// selectObject builds an object with the selected columns, in order
+func selectObject(item model.RawReply, columns []string) model.RawReply {
+	values := model.Select(item, columns)
+	buffer := &bytes.Buffer{}
+	buffer.WriteString("{")
+	for i, column := range columns {
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		name, _ := json.Marshal(model.Column(column))
+		buffer.Write(name)
+		buffer.WriteString(":")
+		if i < len(values) && values[i] != nil {
+			buffer.Write(values[i])
+		} else {
+			buffer.WriteString("null")
+		}
+	}
+	buffer.WriteString("}")
+	return buffer.Bytes()
+}
+
+// objectKeys returns the keys of a JSON object, in order
+func objectKeys(item model.RawReply) []string {
+	decoder := json.NewDecoder(bytes.NewReader(item))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil
+	}
+	keys := make([]string, 0, 16)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return keys
+		}
+		keys = append(keys, token.(string))
+		var skip json.RawMessage
+		if err := decoder.Decode(&skip); err != nil {
+			return keys
+		}
+	}
+	return keys
+}
+
+// header returns the column names for the selectors
+func header(columns []string) []string {
+	names := make([]string, 0, len(columns))
+	for _, column := range columns {
+		names = append(names, model.Column(column))
+	}
+	return names
+}
+
+// rows keeps the columns of tabular formatters. If no columns
+// were selected, they are taken from the keys of the first item.
+type rows struct {
+	options Options
+	columns []string
+}
+
+// cells returns the cells of the item, inferring the columns if needed
+func (r *rows) cells(item model.RawReply) []string {
+	if len(r.columns) == 0 {
+		r.columns = objectKeys(item)
+	}
+	return cells(r.options, model.Select(item, r.columns))
+}
@@ -0,0 +1,69 @@
+package term
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rafahpe/cpcli/model"
+)
+
+func TestFormatters(t *testing.T) {
+	items := []model.RawReply{
+		model.RawReply(`{"name":"a\tb","id":1}`),
+		model.RawReply(`{"name":"long|name","id":22}`),
+	}
+	tests := []struct {
+		options Options
+		columns []string
+		want    string
+	}{
+		{Options{Output: "tsv"}, []string{"name", "id"}, "name\tid\na\\tb\t1\nlong|name\t22\n"},
+		{Options{Output: "tsv", SkipHeaders: true}, []string{"id"}, "1\n22\n"},
+		{Options{Output: "tsv"}, nil, "name\tid\na\\tb\t1\nlong|name\t22\n"},
+		{Options{Output: "table"}, []string{"id", "name"}, "id  name\n1   a b\n22  long|name\n"},
+		{Options{Output: "markdown"}, []string{"name"}, "| name |\n| --- |\n| a\tb |\n| long\\|name |\n"},
+		{Options{Output: "csv"}, []string{"name", "id"}, "name,id\na\tb,1\nlong|name,22\n"},
+		{Options{Output: "ndjson"}, []string{"id"}, "{\"id\":1}\n{\"id\":22}\n"},
+	}
+	for _, test := range tests {
+		buffer := &bytes.Buffer{}
+		f, err := newFormatter(buffer, test.options, test.columns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range items {
+			if err := f.Write(item); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := buffer.String(); got != test.want {
+			t.Errorf("%s %v: got %q, want %q", test.options.Output, test.columns, got, test.want)
+		}
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := newFormatter(&bytes.Buffer{}, Options{Output: "xml"}, nil); err == nil {
+		t.Error("newFormatter(xml) succeeded, want error")
+	}
+}
+
+func TestInvalidNested(t *testing.T) {
+	if _, err := newFormatter(&bytes.Buffer{}, Options{Output: "csv", Nested: "joined"}, nil); err != ErrInvalidNested {
+		t.Errorf("newFormatter(nested=joined) = %v, want %v", err, ErrInvalidNested)
+	}
+}
+
+func TestEmptyTableHeader(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	f, _ := newFormatter(buffer, Options{Output: "table"}, []string{"name"})
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := buffer.String(); got != "name\n" {
+		t.Errorf("got %q, want header only", got)
+	}
+}
@@ -0,0 +1,228 @@
+package term
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	hjson "github.com/hjson/hjson-go"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Input formats for request bodies
+const (
+	// InputJSON is a stream of JSON documents, compact or pretty-printed
+	InputJSON = "json"
+	// InputYAML is a stream of YAML documents, separated by '---'
+	InputYAML = "yaml"
+	// InputHJSON is a single HJSON document
+	InputHJSON = "hjson"
+)
+
+// ErrUnknownInput when the input format is not supported
+const ErrUnknownInput = Error("Unknown input format, must be 'json', 'yaml', 'hjson' or 'csv'")
+
+// NewInput reads request bodies from r, in the given format (default
+// InputJSON). Top-level arrays are split into one body per item.
+func NewInput(r io.Reader, format string) (Input, error) {
+	switch format {
+	case "", InputJSON:
+		return &jsonInput{decoder: json.NewDecoder(r)}, nil
+	case InputYAML:
+		return &yamlInput{decoder: yaml.NewDecoder(r)}, nil
+	case InputHJSON:
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		// Numbers are kept as written, float64 would round big ids
+		var value interface{}
+		if err := hjson.UnmarshalWithOptions(data, &value, hjson.DecoderOptions{UseJSONNumber: true}); err != nil {
+			return nil, err
+		}
+		items, err := split(value)
+		if err != nil {
+			return nil, err
+		}
+		return &hjsonInput{sliceInput{items: items}}, nil
+	case InputCSV:
+		return newCSVInput(r)
+	}
+	return nil, ErrUnknownInput
+}
+
+// Data returns an Input for the --data flag: either an inline body, or
+// "@file" to read the bodies from a file ("@-" for stdin). If no format
+// is given, it is guessed from the file extension.
+func Data(data, format string) (Input, error) {
+	if !strings.HasPrefix(data, "@") {
+		return NewInput(strings.NewReader(data), format)
+	}
+	name := data[1:]
+	if name == "-" {
+		return NewInput(os.Stdin, format)
+	}
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".yaml", ".yml":
+			format = InputYAML
+		case ".hjson":
+			format = InputHJSON
+		case ".csv":
+			format = InputCSV
+		}
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	// Input can't be closed, so the whole file is read in advance
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewInput(bytes.NewReader(content), format)
+}
+
+// split turns a decoded value into a list of JSON bodies,
+// one per item if the value is an array.
+func split(value interface{}) ([]json.RawMessage, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		list = []interface{}{value}
+	}
+	items := make([]json.RawMessage, 0, len(list))
+	for _, item := range list {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, data)
+	}
+	return items, nil
+}
+
+// fromYAML converts the maps decoded by yaml, which may have
+// any type of key, into maps that can be marshalled as JSON.
+func fromYAML(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			result[fmt.Sprint(key)] = fromYAML(item)
+		}
+		return result
+	case []interface{}:
+		for i, item := range value {
+			value[i] = fromYAML(item)
+		}
+		return value
+	}
+	return value
+}
+
+// sliceInput iterates over a list of bodies
+type sliceInput struct {
+	items   []json.RawMessage
+	current json.RawMessage
+}
+
+// Next implements Input
+func (s *sliceInput) Next() bool {
+	if len(s.items) == 0 {
+		return false
+	}
+	s.current, s.items = s.items[0], s.items[1:]
+	return true
+}
+
+// Get implements Input
+func (s *sliceInput) Get() json.RawMessage {
+	return s.current
+}
+
+// Error implements Input
+func (s *sliceInput) Error() error {
+	return nil
+}
+
+// hjsonInput iterates over the bodies of an HJSON document
+type hjsonInput struct {
+	sliceInput
+}
+
+// jsonInput reads a stream of JSON documents
+type jsonInput struct {
+	sliceInput
+	decoder *json.Decoder
+	err     error
+}
+
+// Next implements Input
+func (i *jsonInput) Next() bool {
+	for i.err == nil {
+		if i.sliceInput.Next() {
+			return true
+		}
+		var current json.RawMessage
+		if err := i.decoder.Decode(&current); err != nil {
+			if err != io.EOF {
+				i.err = err
+			}
+			return false
+		}
+		if trimmed := bytes.TrimSpace(current); len(trimmed) == 0 || trimmed[0] != '[' {
+			i.current = current
+			return true
+		}
+		if err := json.Unmarshal(current, &i.items); err != nil {
+			i.err = err
+		}
+	}
+	return false
+}
+
+// Error implements Input
+func (i *jsonInput) Error() error {
+	return i.err
+}
+
+// yamlInput reads a stream of YAML documents
+type yamlInput struct {
+	sliceInput
+	decoder *yaml.Decoder
+	err     error
+}
+
+// Next implements Input
+func (i *yamlInput) Next() bool {
+	for i.err == nil {
+		if i.sliceInput.Next() {
+			return true
+		}
+		var current interface{}
+		if err := i.decoder.Decode(&current); err != nil {
+			if err != io.EOF {
+				i.err = err
+			}
+			return false
+		}
+		if current == nil {
+			// Empty document
+			continue
+		}
+		i.items, i.err = split(fromYAML(current))
+	}
+	return false
+}
+
+// Error implements Input
+func (i *yamlInput) Error() error {
+	return i.err
+}
@@ -0,0 +1,129 @@
+package term
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// bodies reads all the bodies of the input, compacted
+func bodies(input Input) ([]string, error) {
+	result := []string{}
+	for input.Next() {
+		result = append(result, string(input.Get()))
+	}
+	return result, input.Error()
+}
+
+func TestNewInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   []string
+		err    bool
+	}{
+		{"json object", InputJSON, `{"id": 1}`, []string{`{"id": 1}`}, false},
+		{"json default", "", `{"id":1}`, []string{`{"id":1}`}, false},
+		{"json array", InputJSON, `[{"id":1}, {"id":2}]`, []string{`{"id":1}`, `{"id":2}`}, false},
+		{"json stream", InputJSON, "{\"id\":1}\n{\n  \"id\": 2\n}\n[{\"id\":3}]", []string{`{"id":1}`, "{\n  \"id\": 2\n}", `{"id":3}`}, false},
+		{"json empty", InputJSON, "", []string{}, false},
+		{"json empty array", InputJSON, "[]", []string{}, false},
+		{"json broken", InputJSON, `{"id":1}{"id"`, []string{`{"id":1}`}, true},
+		{"yaml object", InputYAML, "id: 1\nname: x\n", []string{`{"id":1,"name":"x"}`}, false},
+		{"yaml array", InputYAML, "- id: 1\n- id: 2\n", []string{`{"id":1}`, `{"id":2}`}, false},
+		{"yaml stream", InputYAML, "id: 1\n---\n---\n- id: 2\n- id: 3\n", []string{`{"id":1}`, `{"id":2}`, `{"id":3}`}, false},
+		{"yaml nested", InputYAML, "attributes:\n  1: one\n", []string{`{"attributes":{"1":"one"}}`}, false},
+		{"yaml broken", InputYAML, "id: [1\n", []string{}, true},
+		{"hjson object", InputHJSON, "{\n  # comment\n  id: 1\n  name: x\n}", []string{`{"id":1,"name":"x"}`}, false},
+		{"hjson array", InputHJSON, "[\n  {id: 1}\n  {id: 2}\n]", []string{`{"id":1}`, `{"id":2}`}, false},
+		{"hjson big number", InputHJSON, "{id: 9007199254740993, ratio: 0.10}", []string{`{"id":9007199254740993,"ratio":0.10}`}, false},
+		{"csv", InputCSV, "id:int,name\n1,x\n2,y\n", []string{`{"id":1,"name":"x"}`, `{"id":2,"name":"y"}`}, false},
+	}
+	for _, test := range tests {
+		input, err := NewInput(strings.NewReader(test.input), test.format)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		got, err := bodies(input)
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNewInputErrors(t *testing.T) {
+	tests := []struct {
+		format string
+		input  string
+	}{
+		{"xml", "<a/>"},
+		{InputHJSON, "{id: "},
+		// HJSON input is a single document
+		{InputHJSON, "{id: 1}\n{id: 2}"},
+	}
+	for _, test := range tests {
+		if _, err := NewInput(strings.NewReader(test.input), test.format); err == nil {
+			t.Errorf("NewInput(%q, %s) got no error", test.input, test.format)
+		}
+	}
+}
+
+func TestData(t *testing.T) {
+	folder := t.TempDir()
+	files := map[string]string{
+		"bodies.json":  `[{"id":1},{"id":2}]`,
+		"bodies.yaml":  "- id: 1\n- id: 2\n",
+		"bodies.YML":   "id: 1\n---\nid: 2\n",
+		"bodies.hjson": "[{id: 1}, {id: 2}]",
+		"bodies.csv":   "id:int\n1\n2\n",
+		"bodies.txt":   `{"id":1} {"id":2}`,
+		"bodies":       `{"id":1} {"id":2}`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(folder, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name   string
+		data   string
+		format string
+		want   []string
+		err    bool
+	}{
+		{"inline", `{"id":1}`, "", []string{`{"id":1}`}, false},
+		{"inline yaml", "id: 1", InputYAML, []string{`{"id":1}`}, false},
+		{"json file", "@bodies.json", "", []string{`{"id":1}`, `{"id":2}`}, false},
+		{"yaml file", "@bodies.yaml", "", []string{`{"id":1}`, `{"id":2}`}, false},
+		{"yml file", "@bodies.YML", "", []string{`{"id":1}`, `{"id":2}`}, false},
+		{"hjson file", "@bodies.hjson", "", []string{`{"id":1}`, `{"id":2}`}, false},
+		{"csv file", "@bodies.csv", "", []string{`{"id":1}`, `{"id":2}`}, false},
+		{"unknown extension", "@bodies.txt", "", []string{`{"id":1}`, `{"id":2}`}, false},
+		{"no extension", "@bodies", "", []string{`{"id":1}`, `{"id":2}`}, false},
+		{"format overrides extension", "@bodies.txt", InputYAML, nil, true},
+		{"missing file", "@missing.json", "", nil, true},
+	}
+	for _, test := range tests {
+		data := test.data
+		if strings.HasPrefix(data, "@") {
+			data = "@" + filepath.Join(folder, data[1:])
+		}
+		input, err := Data(data, test.format)
+		if err == nil {
+			var got []string
+			if got, err = bodies(input); err == nil && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+			}
+		}
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.err)
+		}
+	}
+}
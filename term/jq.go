@@ -0,0 +1,58 @@
+package term
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/itchyny/gojq"
+	"github.com/rafahpe/cpcli/model"
+)
+
+// transform applied to each reply before output. May return
+// any number of replies.
+type transform func(item model.RawReply) ([]model.RawReply, error)
+
+// noTransform returns the item unchanged
+func noTransform(item model.RawReply) ([]model.RawReply, error) {
+	return []model.RawReply{item}, nil
+}
+
+// transform compiles the jq expression in the options, if any
+func (options Options) transform() (transform, error) {
+	if options.Expr == "" {
+		return noTransform, nil
+	}
+	query, err := gojq.Parse(options.Expr)
+	if err != nil {
+		return nil, err
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	return func(item model.RawReply) ([]model.RawReply, error) {
+		var input interface{}
+		decoder := json.NewDecoder(bytes.NewReader(item))
+		decoder.UseNumber()
+		if err := decoder.Decode(&input); err != nil {
+			return nil, err
+		}
+		result := make([]model.RawReply, 0, 1)
+		iter := code.Run(input)
+		for {
+			v, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if err, ok := v.(error); ok {
+				return nil, err
+			}
+			output, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, output)
+		}
+		return result, nil
+	}, nil
+}
@@ -0,0 +1,55 @@
+package term
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rafahpe/cpcli/model"
+)
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		input   string
+		want    []string
+		compile bool // Expected error compiling the expression
+		run     bool // Expected error running the expression
+	}{
+		{"no expression", "", `{"a": 1}`, []string{`{"a": 1}`}, false, false},
+		{"identity", ".", `{"a":1}`, []string{`{"a":1}`}, false, false},
+		{"field", ".name", `{"name":"x","id":1}`, []string{`"x"`}, false, false},
+		{"multiple outputs", ".[]", `{"a":1,"b":2}`, []string{`1`, `2`}, false, false},
+		{"no output", "select(.a > 1)", `{"a":1}`, []string{}, false, false},
+		{"empty", "empty", `{"a":1}`, []string{}, false, false},
+		{"parse error", ".a |", `{}`, nil, true, false},
+		{"compile error", "$undefined", `{}`, nil, true, false},
+		{"runtime error", ".[0]", `{"a":1}`, nil, false, true},
+		{"invalid input", ".", `{`, nil, false, true},
+	}
+	for _, test := range tests {
+		transform, err := Options{Expr: test.expr}.transform()
+		if (err != nil) != test.compile {
+			t.Errorf("%s: compile error = %v, want error %v", test.name, err, test.compile)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		result, err := transform(model.RawReply(test.input))
+		if (err != nil) != test.run {
+			t.Errorf("%s: run error = %v, want error %v", test.name, err, test.run)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got := make([]string, 0, len(result))
+		for _, item := range result {
+			got = append(got, string(item))
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") || len(got) != len(test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -2,62 +2,70 @@ package term
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"strings"
+	"io"
+	"os"
 
 	"github.com/rafahpe/cpcli/model"
 )
 
-// Options stores pagination options
+// Error type for predefined errors
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Options stores pagination and output options
 type Options struct {
 	PageSize    int
 	Paginate    bool
 	SkipHeaders bool
 	PrettyPrint bool
+	Output      string // Name of the formatter, see RegisterFormat
+	Expr        string // jq expression applied to each reply
+	// CSV output options
+	Delimiter string // Single character, or "\t" for tabs
+	Nested    string // NestedJSON or NestedJoin
+	LegacyCSV bool   // JSON values separated by ';', without quoting
 }
 
 // Output the feed of replies, printing the given columns (if any)
 func Output(ctx context.Context, options Options, pages *model.Reply, format []string) error {
-	// If output is CSV-like, dump the header
-	if format != nil && len(format) > 0 && !options.SkipHeaders {
-		fmt.Println(strings.Join(format, ";"))
+	return OutputTo(ctx, os.Stdout, options, pages, format)
+}
+
+// OutputTo writes the feed of replies to w, printing the given columns (if any)
+func OutputTo(ctx context.Context, w io.Writer, options Options, pages *model.Reply, format []string) error {
+	f, err := newFormatter(w, options, format)
+	if err != nil {
+		return err
 	}
+	xform, err := options.transform()
+	if err != nil {
+		return err
+	}
+	// Stop fetching pages if the user quits, or on error
+	defer pages.Close()
 	// Keep reading pages of data
 	p := options.newPaginator()
 	for pages.Next(ctx) {
 		// Show next item
-		page := pages.Get()
-		output, err := serialize(options, page, format)
+		items, err := xform(pages.Get())
 		if err != nil {
 			return err
 		}
-		fmt.Println(output)
+		for _, item := range items {
+			if err := f.Write(item); err != nil {
+				return err
+			}
+		}
 		// Check next page
-		if ok, err := p.next(); !ok || err != nil {
+		if ok, err := p.next(f.Flush); !ok || err != nil {
 			return err
 		}
 	}
-	if err := pages.Error(); pages != nil {
+	if err := f.Flush(); err != nil {
 		return err
 	}
-	return nil
-}
-
-// Serializes a reply according to the options
-func serialize(options Options, item model.RawReply, format []string) (string, error) {
-	if format != nil && len(format) > 0 {
-		return model.ToCSV(item, format), nil
-	}
-	var output []byte
-	var err error
-	if options.PrettyPrint {
-		output, err = json.MarshalIndent(item, "", "  ")
-	} else {
-		output, err = json.Marshal(item)
-	}
-	if err != nil {
-		return "", err
-	}
-	return string(output), nil
+	return pages.Error()
 }
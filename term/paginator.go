@@ -26,14 +26,18 @@ func (options Options) newPaginator() paginator {
 	}
 }
 
-// next checks if the user wants to advance to following page
-func (p *paginator) next() (bool, error) {
+// next checks if the user wants to advance to following page.
+// flush is called before prompting, to show any buffered output.
+func (p *paginator) next(flush func() error) (bool, error) {
 	if p.reader == nil {
 		// If no pagination, just keep going
 		return true, nil
 	}
 	p.lineno++
 	if p.lineno >= p.pageSize {
+		if err := flush(); err != nil {
+			return false, err
+		}
 		p.logger.Println("Press q to quit, enter to continue")
 		r, _, err := p.reader.ReadRune()
 		if err != nil {
@@ -1,7 +1,6 @@
 package term
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -24,13 +23,6 @@ type Input interface {
 	Error() error         // Error returns the non-nil error if the iteration broke
 }
 
-// inputReader implements a line-oriented stdin reader
-type reader struct {
-	scanner *bufio.Scanner
-	current json.RawMessage
-	err     error
-}
-
 // once implements a one-shot Input
 type once struct {
 	value json.RawMessage
@@ -42,8 +34,9 @@ func Once(msg json.RawMessage) Input {
 	return &once{value: msg}
 }
 
-// Stdin returns a Input stream if stdin is not a tty, nil otherwise
-func Stdin() (Input, error) {
+// Stdin returns a Input stream if stdin is not a tty, nil otherwise.
+// 'format' is one of the input formats, see NewInput.
+func Stdin(format string) (Input, error) {
 	stat, err := os.Stdin.Stat()
 	if err != nil {
 		return nil, fmt.Errorf("Error stating os.Stdin: %s", err)
@@ -51,9 +44,13 @@ func Stdin() (Input, error) {
 	if (stat.Mode() & os.ModeCharDevice) != 0 {
 		return nil, nil
 	}
-	return &reader{
-		scanner: bufio.NewScanner(os.Stdin),
-	}, nil
+	return NewInput(os.Stdin, format)
+}
+
+// Interactive checks if stdin is a terminal, so the user can be prompted
+func Interactive() bool {
+	stat, err := os.Stdin.Stat()
+	return err == nil && (stat.Mode()&os.ModeCharDevice) != 0
 }
 
 // Readline reads a single line of input
@@ -74,33 +71,6 @@ func Readline(prompt string, password bool) (string, error) {
 	return strings.TrimSpace(result), nil
 }
 
-// Next implements model.Reply
-func (i *reader) Next() bool {
-	if i.scanner == nil || i.err != nil {
-		return false
-	}
-	if !i.scanner.Scan() {
-		i.scanner = nil
-		return false
-	}
-	if err := json.Unmarshal(i.scanner.Bytes(), &(i.current)); err != nil {
-		i.scanner = nil
-		i.err = err
-		return false
-	}
-	return true
-}
-
-// Get implements Input
-func (i *reader) Get() json.RawMessage {
-	return i.current
-}
-
-// Error implements Imput
-func (i *reader) Error() error {
-	return i.err
-}
-
 // Next implements Input
 func (o *once) Next() bool {
 	done := o.done
@@ -0,0 +1,89 @@
+package term
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Rejects writes the bodies of failed requests in the format of the
+// Input they were read from, so they can be replayed with the same
+// --input-format.
+type Rejects struct {
+	w      io.Writer
+	format string
+	header []string // Header row of CSV inputs
+	count  int
+}
+
+// NewRejects returns a Rejects for the bodies read from the input
+func NewRejects(w io.Writer, input Input) *Rejects {
+	rejects := &Rejects{w: w, format: InputJSON}
+	switch input := input.(type) {
+	case *csvInput:
+		rejects.format, rejects.header = InputCSV, input.header
+	case *yamlInput:
+		rejects.format = InputYAML
+	case *hjsonInput:
+		rejects.format = InputHJSON
+	}
+	return rejects
+}
+
+// Record returns the row of the current body, if the input is CSV.
+// CSV rejects are written as they were read, so type hints still apply.
+func Record(input Input) []string {
+	if input, ok := input.(*csvInput); ok {
+		return input.record
+	}
+	return nil
+}
+
+// Write saves a failed body, with the Record it was read from
+func (r *Rejects) Write(body json.RawMessage, record []string) error {
+	if len(body) == 0 {
+		body = json.RawMessage("null")
+	}
+	// One line per body, even if it was pretty-printed
+	line := &bytes.Buffer{}
+	if err := json.Compact(line, body); err != nil {
+		return err
+	}
+	first := r.count == 0
+	r.count++
+	switch r.format {
+	case InputCSV:
+		w := csv.NewWriter(r.w)
+		if first {
+			w.Write(r.header)
+		}
+		w.Write(record)
+		w.Flush()
+		return w.Error()
+	case InputYAML:
+		// JSON documents are valid YAML
+		_, err := fmt.Fprintf(r.w, "---\n%s\n", line)
+		return err
+	case InputHJSON:
+		// HJSON input is a single document, bodies are saved as an array
+		separator := ",\n"
+		if first {
+			separator = "[\n"
+		}
+		_, err := fmt.Fprintf(r.w, "%s%s", separator, line)
+		return err
+	}
+	_, err := fmt.Fprintf(r.w, "%s\n", line)
+	return err
+}
+
+// Close finishes the HJSON array, if any body was written
+func (r *Rejects) Close() error {
+	if r.format != InputHJSON || r.count == 0 {
+		return nil
+	}
+	_, err := fmt.Fprint(r.w, "\n]\n")
+	return err
+}
@@ -0,0 +1,72 @@
+package term
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRejects(t *testing.T) {
+	tests := []struct {
+		format string
+		input  string
+		want   string
+	}{
+		{InputJSON, "{\"id\": 1}\n{\"id\": 2}\n{\"id\": 3}\n", "{\"id\":1}\n{\"id\":3}\n"},
+		{InputJSON, "[{\"id\": 1}, {\"id\": 2}, {\"id\": 3}]", "{\"id\":1}\n{\"id\":3}\n"},
+		{InputYAML, "id: 1\n---\nid: 2\n---\nid: 3\n", "---\n{\"id\":1}\n---\n{\"id\":3}\n"},
+		{InputHJSON, "[{id: 1}, {id: 2}, {id: 3}]", "[\n{\"id\":1},\n{\"id\":3}\n]\n"},
+		{InputCSV, "\uFEFFid:int,name\n1,a\n2,b\n003,\"c, d\"\n", "id:int,name\n1,a\n003,\"c, d\"\n"},
+	}
+	for _, test := range tests {
+		input, err := NewInput(strings.NewReader(test.input), test.format)
+		if err != nil {
+			t.Fatalf("%s: %s", test.format, err)
+		}
+		output := &bytes.Buffer{}
+		rejects := NewRejects(output, input)
+		// Reject every other body
+		for i := 0; input.Next(); i++ {
+			if i%2 == 0 {
+				if err := rejects.Write(input.Get(), Record(input)); err != nil {
+					t.Fatalf("%s: %s", test.format, err)
+				}
+			}
+		}
+		if err := rejects.Close(); err != nil {
+			t.Fatalf("%s: %s", test.format, err)
+		}
+		if output.String() != test.want {
+			t.Errorf("%s: got %q, want %q", test.format, output.String(), test.want)
+			continue
+		}
+		// Rejects can be replayed with the same format
+		replay, err := NewInput(output, test.format)
+		if err != nil {
+			t.Fatalf("%s: replay: %s", test.format, err)
+		}
+		count := 0
+		for replay.Next() {
+			count++
+		}
+		if count != 2 || replay.Error() != nil {
+			t.Errorf("%s: replayed %d bodies, %v, want 2", test.format, count, replay.Error())
+		}
+	}
+}
+
+func TestRejectsWithoutFailures(t *testing.T) {
+	for _, format := range []string{InputJSON, InputYAML, InputHJSON, InputCSV} {
+		input, err := NewInput(strings.NewReader("[]"), format)
+		if format == InputCSV {
+			input, err = NewInput(strings.NewReader("id\n"), format)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := &bytes.Buffer{}
+		if err := NewRejects(output, input).Close(); err != nil || output.Len() != 0 {
+			t.Errorf("%s: got %q, %v, want nothing", format, output.String(), err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package term
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rafahpe/cpcli/model"
+)
+
+// tableWindow is the number of rows buffered to compute column widths
+const tableWindow = 100
+
+// tableEscaper keeps every cell in a single line
+var tableEscaper = strings.NewReplacer("\t", " ", "\n", "\\n", "\r", "\\r")
+
+// markdownEscaper escapes the characters that would break a markdown table
+var markdownEscaper = strings.NewReplacer("|", "\\|", "\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+// tableFormatter writes aligned columns. Rows are buffered in windows,
+// and columns are sized to fit the widest cell seen so far.
+type tableFormatter struct {
+	w io.Writer
+	rows
+	header  bool // header already written, or skipped
+	widths  []int
+	pending [][]string
+}
+
+// markdownFormatter writes a markdown table
+type markdownFormatter struct {
+	w io.Writer
+	rows
+	header bool // header already written, or skipped
+}
+
+func init() {
+	RegisterFormat("table", func(w io.Writer, options Options, columns []string) Formatter {
+		return &tableFormatter{w: w, rows: rows{options: options, columns: columns}, header: options.SkipHeaders}
+	})
+	RegisterFormat("markdown", func(w io.Writer, options Options, columns []string) Formatter {
+		return &markdownFormatter{w: w, rows: rows{options: options, columns: columns}, header: options.SkipHeaders}
+	})
+}
+
+// Write implements Formatter
+func (f *tableFormatter) Write(item model.RawReply) error {
+	cells := f.cells(item)
+	for i, cell := range cells {
+		cells[i] = tableEscaper.Replace(cell)
+	}
+	f.pending = append(f.pending, cells)
+	if len(f.pending) >= tableWindow {
+		return f.Flush()
+	}
+	return nil
+}
+
+// fit widens the columns to fit the cells
+func (f *tableFormatter) fit(cells []string) {
+	for len(f.widths) < len(cells) {
+		f.widths = append(f.widths, 0)
+	}
+	for i, cell := range cells {
+		if width := utf8.RuneCountInString(cell); width > f.widths[i] {
+			f.widths[i] = width
+		}
+	}
+}
+
+// writeLine writes the cells padded to the column widths
+func (f *tableFormatter) writeLine(cells []string) error {
+	line := make([]string, 0, len(cells))
+	for i, cell := range cells {
+		if i < len(cells)-1 {
+			cell += strings.Repeat(" ", f.widths[i]-utf8.RuneCountInString(cell))
+		}
+		line = append(line, cell)
+	}
+	_, err := fmt.Fprintln(f.w, strings.Join(line, "  "))
+	return err
+}
+
+// Flush implements Formatter
+func (f *tableFormatter) Flush() error {
+	if !f.header {
+		f.fit(header(f.columns))
+	}
+	for _, cells := range f.pending {
+		f.fit(cells)
+	}
+	if !f.header && len(f.columns) > 0 {
+		f.header = true
+		if err := f.writeLine(header(f.columns)); err != nil {
+			return err
+		}
+	}
+	for _, cells := range f.pending {
+		if err := f.writeLine(cells); err != nil {
+			return err
+		}
+	}
+	f.pending = f.pending[:0]
+	return nil
+}
+
+// writeLine writes a markdown table row
+func (f *markdownFormatter) writeLine(cells []string) error {
+	escaped := make([]string, 0, len(cells))
+	for _, cell := range cells {
+		escaped = append(escaped, markdownEscaper.Replace(cell))
+	}
+	_, err := fmt.Fprintf(f.w, "| %s |\n", strings.Join(escaped, " | "))
+	return err
+}
+
+// writeHeader writes the header and separator rows, if not written yet
+func (f *markdownFormatter) writeHeader() error {
+	if f.header || len(f.columns) == 0 {
+		return nil
+	}
+	f.header = true
+	if err := f.writeLine(header(f.columns)); err != nil {
+		return err
+	}
+	separator := make([]string, len(f.columns))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	_, err := fmt.Fprintf(f.w, "| %s |\n", strings.Join(separator, " | "))
+	return err
+}
+
+// Write implements Formatter
+func (f *markdownFormatter) Write(item model.RawReply) error {
+	cells := f.cells(item)
+	if err := f.writeHeader(); err != nil {
+		return err
+	}
+	return f.writeLine(cells)
+}
+
+// Flush implements Formatter
+func (f *markdownFormatter) Flush() error {
+	return f.writeHeader()
+}
@@ -0,0 +1,91 @@
+package term
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/rafahpe/cpcli/model"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// yamlFormatter writes each item as a YAML document
+type yamlFormatter struct {
+	w       io.Writer
+	columns []string
+}
+
+func init() {
+	RegisterFormat("yaml", func(w io.Writer, options Options, columns []string) Formatter {
+		return yamlFormatter{w: w, columns: columns}
+	})
+}
+
+// Write implements Formatter
+func (f yamlFormatter) Write(item model.RawReply) error {
+	if len(f.columns) > 0 {
+		item = selectObject(item, f.columns)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(item))
+	decoder.UseNumber()
+	value, err := toYAML(decoder)
+	if err != nil {
+		return err
+	}
+	output, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f.w, "---\n%s", output)
+	return err
+}
+
+// Flush implements Formatter
+func (f yamlFormatter) Flush() error {
+	return nil
+}
+
+// toYAML decodes the next JSON value, keeping the order of object keys
+func toYAML(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '{' {
+			object := yaml.MapSlice{}
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := toYAML(decoder)
+				if err != nil {
+					return nil, err
+				}
+				object = append(object, yaml.MapItem{Key: key, Value: value})
+			}
+			_, err := decoder.Token() // closing '}'
+			return object, err
+		}
+		list := make([]interface{}, 0, 8)
+		for decoder.More() {
+			value, err := toYAML(decoder)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := decoder.Token() // closing ']'
+		return list, err
+	case json.Number:
+		if i, err := token.Int64(); err == nil {
+			return i, nil
+		}
+		return token.Float64()
+	default:
+		return token, nil
+	}
+}